@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage/redis"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/tilesets"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+// heatmap writes a bench density map of the loaded benches to a PNG file.
+func heatmap(args []string) error {
+	fs := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	district := fs.String("district", "", "only map this district, by name or code")
+	mode := fs.String("mode", string(maps.HeatmapNeighborhood), "group benches by neighborhood or grid")
+	cell := fs.Float64("cell", maps.DefaultCellSize, "side of grid cells in metres")
+	style := fs.String("style", string(maps.StyleStandard), "map style")
+	theme := fs.String("theme", maps.ThemeDefault, "map theme, built in or from MAP_THEMES_FILE")
+	width := fs.Int("width", 1600, "image width in pixels")
+	height := fs.Int("height", 1200, "image height in pixels")
+	title := fs.String("title", "Benches", "title of the colour scale, empty to leave it out")
+	out := fs.String("o", "heatmap.png", "file to write")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s heatmap [flags]\n\nWrites a bench density map of the benches in Redis.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	req := maps.HeatmapRequest{
+		Mode:     maps.HeatmapMode(*mode),
+		CellSize: *cell,
+		Style:    maps.Style(*style),
+		Title:    *title,
+		Width:    *width,
+		Height:   *height,
+	}
+	if !req.Mode.Valid() {
+		return fmt.Errorf("unknown heatmap mode %q", *mode)
+	}
+	if !req.Style.Valid() {
+		return fmt.Errorf("unknown map style %q", *style)
+	}
+	if *width <= 0 || *height <= 0 || *cell <= 0 {
+		return fmt.Errorf("width, height and cell must be positive")
+	}
+
+	cfg, err := config.LoadToolConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store := redis.NewBenchStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	defer store.Close()
+	benches, err := store.AllBenches(ctx)
+	if err != nil {
+		return fmt.Errorf("loading benches: %w", err)
+	}
+	if *district != "" {
+		name, ok := bench.FindDistrict(benches, *district)
+		if !ok {
+			return fmt.Errorf("unknown district %q, try one of: %s", *district, strings.Join(bench.Districts(benches), ", "))
+		}
+		benches = bench.InDistrict(benches, name)
+	}
+	req.Benches = benches
+
+	themes, err := maps.LoadThemes(cfg.MapThemesFile)
+	if err != nil {
+		return err
+	}
+	if !themes.Has(*theme) {
+		return fmt.Errorf("unknown theme %q, try one of: %s", *theme, strings.Join(themes.Names(), ", "))
+	}
+	req.Theme = themes.Get(*theme)
+
+	sets, err := tilesets.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer sets.Close()
+	opts := append(sets.RendererOptions(), maps.WithEncoding(maps.DefaultEncoding))
+	renderer := maps.NewRenderer(opts...)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := renderer.RenderHeatmap(ctx, f, req); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cells := maps.Heatmap(req)
+	log.Printf("wrote %s: %d benches in %d cells", *out, len(benches), len(cells))
+	return nil
+}
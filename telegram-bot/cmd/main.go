@@ -2,24 +2,43 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/go-telegram/bot"
-	"github.com/go-telegram/bot/models"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/handlers"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/telegram"
 )
 
-const nrShutdownTimeout = 5 * time.Second
+const (
+	nrShutdownTimeout    = 5 * time.Second
+	apiReadHeaderTimeout = 10 * time.Second
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "seed-tiles":
+			if err := seedTiles(os.Args[2:]); err != nil {
+				log.Fatalf("error seeding tiles: %v", err)
+			}
+			return
+		case "heatmap":
+			if err := heatmap(os.Args[2:]); err != nil {
+				log.Fatalf("error writing heatmap: %v", err)
+			}
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -44,14 +63,20 @@ func main() {
 
 	ctx = newrelic.NewContext(ctx, mainTxn)
 
+	app, err := handlers.NewApp(cfg, nrApp)
+	if err != nil {
+		mainTxn.NoticeError(err)
+		log.Fatalf("error creating app: %v", err)
+	}
+
+	// Routes need the bot's username, so they are registered once the
+	// client exists.
+	// Enqueue hands updates over to the app's own queue, so the bot must
+	// not start a goroutine per update: its blocking is what slows down
+	// fetching when the queue is full.
 	opts := []bot.Option{
-		bot.WithDefaultHandler(func(ctx context.Context, b *bot.Bot, update *models.Update) {
-			updateTxn := nrApp.StartTransaction("bot_update")
-			updateTxn.AddAttribute("update_type", "message")
-			updateCtx := newrelic.NewContext(ctx, updateTxn)
-			defer updateTxn.End()
-			handlers.Handler(updateCtx, b, update)
-		}),
+		bot.WithDefaultHandler(app.Enqueue),
+		bot.WithNotAsyncHandlers(),
 	}
 
 	segment := mainTxn.StartSegment("bot_init")
@@ -60,15 +85,58 @@ func main() {
 		mainTxn.NoticeError(err)
 		log.Fatalf("error creating telegram client: %v", err)
 	}
+
+	me, err := b.GetMe(ctx)
+	if err != nil {
+		mainTxn.NoticeError(err)
+		log.Fatalf("error getting bot user: %v", err)
+	}
+	app.Route(me.Username)
 	segment.End()
 
 	// Start bot in goroutine
-	errChan := make(chan error, 1)
+	errChan := make(chan error, 2)
+	done := make(chan struct{})
 	go func() {
-		log.Println("Bot started successfully")
-		b.Start(ctx)
+		defer close(done)
+		if cfg.WebhookURL == "" {
+			log.Println("Bot started successfully")
+			b.Start(ctx)
+			return
+		}
+
+		log.Println("Bot started successfully in webhook mode")
+		err := b.StartWebhook(ctx, telegram.WebhookConfig{
+			URL:          cfg.WebhookURL,
+			ListenAddr:   cfg.WebhookListenAddr,
+			SecretToken:  cfg.WebhookSecret,
+			DeleteOnStop: cfg.WebhookDeleteOnStop,
+		})
+		if err != nil {
+			errChan <- err
+		}
 	}()
 
+	var apiServer *http.Server
+	if cfg.APIListenAddr != "" {
+		handler, err := app.APIHandler()
+		if err != nil {
+			mainTxn.NoticeError(err)
+			log.Fatalf("error creating API: %v", err)
+		}
+		apiServer = &http.Server{
+			Addr:              cfg.APIListenAddr,
+			Handler:           handler,
+			ReadHeaderTimeout: apiReadHeaderTimeout,
+		}
+		go func() {
+			log.Printf("API listening on %s", cfg.APIListenAddr)
+			if err := apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errChan <- fmt.Errorf("serving API: %w", err)
+			}
+		}()
+	}
+
 	// Wait for shutdown signal or error
 	select {
 	case <-sigChan:
@@ -81,6 +149,24 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), nrShutdownTimeout)
 	defer shutdownCancel()
 
+	// Stop receiving updates and let the webhook unregister itself.
+	cancel()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		log.Println("Timed out waiting for the bot to stop")
+	}
+
+	if apiServer != nil {
+		if err := apiServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error shutting down API: %v", err)
+		}
+	}
+
+	// Queued updates are still answered, so the bot is closed after them.
+	if err := app.Close(shutdownCtx); err != nil {
+		log.Printf("error closing app: %v", err)
+	}
 	b.Close(shutdownCtx)
 	log.Println("Bot shutdown initiated")
 	log.Println("Bot shutdown complete")
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage/redis"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/tilesets"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+// barcelonaBBox covers the city of Barcelona.
+const barcelonaBBox = "41.317,2.052,41.468,2.229"
+
+// seedTiles fills the tile cache so that the first maps render quickly. It
+// can be interrupted and run again to resume.
+func seedTiles(args []string) error {
+	fs := flag.NewFlagSet("seed-tiles", flag.ExitOnError)
+	bboxFlag := fs.String("bbox", barcelonaBBox, "area to seed as south,west,north,east")
+	fromBenches := fs.Bool("benches", false, "seed the extent of the loaded benches instead of -bbox")
+	zoomFlag := fs.String("zoom", "12-17", "zoom level or range, such as 15 or 12-17")
+	stylesFlag := fs.String("styles", string(maps.StyleStandard), "comma separated map styles, or all")
+	from := fs.String("from", "", "import tiles from this source (see TILE_SOURCES) instead of the public tile servers")
+	concurrency := fs.Int("concurrency", 2, "tiles fetched at once; keep it low for public tile servers")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s seed-tiles [flags]\n\nFills the tile cache in TILE_CACHE_DIR.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := config.LoadToolConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	minZoom, maxZoom, err := parseZoomRange(*zoomFlag)
+	if err != nil {
+		return err
+	}
+	styles, err := parseStyles(*stylesFlag)
+	if err != nil {
+		return err
+	}
+
+	bbox, err := tiles.ParseBBox(*bboxFlag)
+	if err != nil {
+		return err
+	}
+	if *fromBenches {
+		if bbox, err = benchExtent(ctx, cfg); err != nil {
+			return err
+		}
+	}
+
+	var source tiles.Source
+	if *from != "" {
+		if source, err = tiles.Open(*from, cfg.TileUserAgent); err != nil {
+			return err
+		}
+	} else if cfg.TileOffline {
+		return fmt.Errorf("TILE_OFFLINE is set, use -from to import tiles")
+	}
+
+	sets, err := tilesets.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer sets.Close()
+	if sets.Cache == nil {
+		return fmt.Errorf("the tile cache is disabled, set TILE_CACHE_MAX_MB")
+	}
+
+	total := bbox.Count(minZoom, maxZoom)
+	log.Printf("seeding %d tiles per style for %s, zoom %d-%d, into %s", total, styles, minZoom, maxZoom, cfg.TileCacheDir)
+
+	for _, style := range styles {
+		src := source
+		if src == nil {
+			src = style.DefaultSource(cfg.TileUserAgent)
+		}
+
+		start := time.Now()
+		last := start
+		progress := func(s tiles.SeedStats) {
+			if time.Since(last) < time.Second && s.Done < total {
+				return
+			}
+			last = time.Now()
+			log.Printf("%s: %d/%d tiles (%.0f%%), %d fetched, %d skipped, %d missing, %d failed, %s",
+				style, s.Done, total, 100*float64(s.Done)/float64(total), s.Fetched, s.Skipped, s.Missing, s.Failed, formatBytes(s.Bytes))
+		}
+
+		stats, err := sets.Cache.Seed(ctx, tilesets.CacheName(style), src, bbox.Tiles(minZoom, maxZoom), *concurrency, progress)
+		if err != nil {
+			return fmt.Errorf("seeding %s stopped after %d of %d tiles, run again to resume: %w", style, stats.Done, total, err)
+		}
+		log.Printf("%s: done in %s, %d fetched (%s), %d already cached, %d missing, %d failed",
+			style, time.Since(start).Round(time.Second), stats.Fetched, formatBytes(stats.Bytes), stats.Skipped, stats.Missing, stats.Failed)
+	}
+
+	count, size := sets.Cache.Stats()
+	log.Printf("tile cache holds %d tiles, %s of %d MB", count, formatBytes(size), cfg.TileCacheMaxMB)
+	if size > int64(cfg.TileCacheMaxMB)<<20*9/10 {
+		log.Printf("the tile cache is nearly full and may have evicted seeded tiles, consider raising TILE_CACHE_MAX_MB")
+	}
+	return nil
+}
+
+func benchExtent(ctx context.Context, cfg *config.Config) (tiles.BBox, error) {
+	store := redis.NewBenchStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	defer store.Close()
+
+	south, west, north, east, err := store.BenchExtent(ctx)
+	if err != nil {
+		return tiles.BBox{}, fmt.Errorf("getting bench extent: %w", err)
+	}
+	return tiles.BBox{South: south, West: west, North: north, East: east}, nil
+}
+
+func parseZoomRange(s string) (int, int, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	minZoom, err1 := strconv.Atoi(lo)
+	maxZoom, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || minZoom < 0 || maxZoom > 20 || minZoom > maxZoom {
+		return 0, 0, fmt.Errorf("zoom %q must be a level or range between 0 and 20", s)
+	}
+	return minZoom, maxZoom, nil
+}
+
+func parseStyles(s string) ([]maps.Style, error) {
+	if s == "all" {
+		return maps.Styles, nil
+	}
+	var styles []maps.Style
+	for _, name := range strings.Split(s, ",") {
+		style := maps.Style(strings.TrimSpace(name))
+		if !style.Valid() {
+			return nil, fmt.Errorf("unknown map style %q", name)
+		}
+		styles = append(styles, style)
+	}
+	return styles, nil
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,201 @@
+// Package api serves the bench data over a public, read-only HTTP API. The
+// endpoints are described in openapi.json, served at /v1/openapi.json.
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// Limiter limits requests per client address.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration)
+}
+
+// Config holds the dependencies of a Server.
+type Config struct {
+	Store storage.BenchStorage
+	// Renderer draws the maps, which are always sent as PNG.
+	Renderer *maps.Renderer
+	Themes   *maps.Themes
+	// Global limits all clients together, Requests every request of a
+	// client, Renders also their map requests.
+	Global   Limiter
+	Requests Limiter
+	Renders  Limiter
+	// RenderWorker takes one of the workers that draw maps, shared with the
+	// bot. Map requests are turned away while none is free.
+	RenderWorker func() (release func(), ok bool)
+	// NewRelic may be nil when New Relic is not configured.
+	NewRelic *newrelic.Application
+	// TrustedProxies are the proxies whose X-Forwarded-For header names the
+	// client, for the API served behind a reverse proxy.
+	TrustedProxies []netip.Prefix
+}
+
+// Server is the HTTP handler of the API.
+type Server struct {
+	cfg Config
+	mux *http.ServeMux
+}
+
+func New(cfg Config) *Server {
+	s := &Server{cfg: cfg, mux: http.NewServeMux()}
+	s.handle("GET /v1/benches/nearby", s.requests(s.nearbyHandler))
+	s.handle("GET /v1/benches/nearby/map.png", s.requests(s.renders(s.mapHandler)))
+	s.handle("GET /v1/benches/{gis_id}", s.requests(s.benchHandler))
+	s.handle("GET /v1/openapi.json", s.openAPIHandler)
+	return s
+}
+
+func (s *Server) handle(pattern string, h http.HandlerFunc) {
+	s.mux.HandleFunc(newrelic.WrapHandleFunc(s.cfg.NewRelic, pattern, h))
+}
+
+// ServeHTTP serves the API. Every origin may read it, so that web pages can
+// call it directly.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
+// requests applies the global and request limits, renders the render limit.
+func (s *Server) requests(next http.HandlerFunc) http.HandlerFunc {
+	return s.global(s.limit(s.cfg.Requests, next))
+}
+
+// global turns every client away while the API as a whole is too busy.
+func (s *Server) global(next http.HandlerFunc) http.HandlerFunc {
+	if s.cfg.Global == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := s.cfg.Global.Allow(r.Context(), "api:global"); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			writeError(w, http.StatusServiceUnavailable, "too busy")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) renders(next http.HandlerFunc) http.HandlerFunc {
+	return s.limit(s.cfg.Renders, next)
+}
+
+func (s *Server) limit(l Limiter, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := l.Allow(r.Context(), "api:"+s.clientAddr(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			writeError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientAddr returns the address of the client of r. Requests from trusted
+// proxies are attributed to the last address in X-Forwarded-For that is not
+// a trusted proxy itself, as the addresses before it may be forged.
+func (s *Server) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.trusted(host) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		host = hop
+		if !s.trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (s *Server) trusted(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range s.cfg.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+// internalError reports err and answers with the status matching it.
+func internalError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The client went away, nobody reads the answer.
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	log.Printf("error %s: %v", op, err)
+	newrelic.FromContext(r.Context()).NoticeError(err)
+	if errors.Is(err, storage.ErrUnavailable) {
+		writeError(w, http.StatusServiceUnavailable, "bench database unavailable")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
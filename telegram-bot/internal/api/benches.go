@@ -0,0 +1,345 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/i18n"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+// Bounds of the query parameters. Radii match what the bot offers.
+const (
+	defaultRadius = 250
+	maxRadius     = 1000
+	defaultLimit  = 20
+	maxLimit      = 100
+	minMapSize    = 200
+	maxMapSize    = 1280
+)
+
+// benchJSON is a bench as sent by the API.
+type benchJSON struct {
+	GisID            string   `json:"gis_id"`
+	Address          string   `json:"address"`
+	Latitude         float64  `json:"latitude"`
+	Longitude        float64  `json:"longitude"`
+	Type             string   `json:"type,omitempty"`
+	Code             string   `json:"code,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Manufacturer     string   `json:"manufacturer,omitempty"`
+	DistrictCode     string   `json:"district_code,omitempty"`
+	DistrictName     string   `json:"district_name,omitempty"`
+	NeighborhoodCode string   `json:"neighborhood_code,omitempty"`
+	NeighborhoodName string   `json:"neighborhood_name,omitempty"`
+	Zone             string   `json:"zone,omitempty"`
+	StreetName       string   `json:"street_name,omitempty"`
+	StreetNumber     string   `json:"street_number,omitempty"`
+	CreatedAt        string   `json:"created_at,omitempty"`
+	DeletedAt        string   `json:"deleted_at,omitempty"`
+	Community        bool     `json:"community"`
+	Distance         *float64 `json:"distance_m,omitempty"`
+}
+
+func newBenchJSON(b bench.Bench) benchJSON {
+	j := benchJSON{
+		GisID:            b.GisID,
+		Address:          b.Address(),
+		Latitude:         b.Latitude,
+		Longitude:        b.Longitude,
+		Type:             b.Type,
+		Code:             b.Code,
+		Description:      b.Description,
+		Manufacturer:     b.Manufacturer,
+		DistrictCode:     b.DistrictCode,
+		DistrictName:     b.DistrictName,
+		NeighborhoodCode: b.NeighborhoodCode,
+		NeighborhoodName: b.NeighborhoodName,
+		Zone:             b.Zone,
+		StreetName:       b.StreetName,
+		StreetNumber:     b.StreetNumber,
+		CreatedAt:        b.CreatedAt,
+		DeletedAt:        b.DeletedAt,
+		Community:        b.Community,
+	}
+	if b.Distance > 0 {
+		d := math.Round(b.Distance*10) / 10
+		j.Distance = &d
+	}
+	return j
+}
+
+// page tells where a page of results sits among all of them. Next is the
+// URL of the following page, empty on the last one.
+type page struct {
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Next   string `json:"next,omitempty"`
+}
+
+type nearbyResponse struct {
+	page
+	Benches []benchJSON `json:"benches"`
+}
+
+// nearbyFeatures is a page of results as GeoJSON. The page fields are
+// foreign members of the feature collection.
+type nearbyFeatures struct {
+	bench.FeatureCollection
+	page
+}
+
+// search is a nearby search, as given in the query string.
+type search struct {
+	Lat, Lon  float64
+	Radius    float64
+	Community bool
+}
+
+func parseSearch(q url.Values) (search, error) {
+	var s search
+	var err error
+	if s.Lat, err = floatParam(q, "lat", math.NaN(), -90, 90); err != nil {
+		return s, err
+	}
+	if s.Lon, err = floatParam(q, "lon", math.NaN(), -180, 180); err != nil {
+		return s, err
+	}
+	if s.Radius, err = floatParam(q, "radius", defaultRadius, 1, maxRadius); err != nil {
+		return s, err
+	}
+	s.Community = true
+	if v := q.Get("community"); v != "" {
+		if s.Community, err = strconv.ParseBool(v); err != nil {
+			return s, fmt.Errorf("community must be true or false")
+		}
+	}
+	return s, nil
+}
+
+// floatParam parses the parameter name, which must lie between lo and hi.
+// It is required when def is NaN.
+func floatParam(q url.Values, name string, def, lo, hi float64) (float64, error) {
+	v := q.Get(name)
+	if v == "" {
+		if math.IsNaN(def) {
+			return 0, fmt.Errorf("%s is required", name)
+		}
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || f < lo || f > hi {
+		return 0, fmt.Errorf("%s must be a number between %g and %g", name, lo, hi)
+	}
+	return f, nil
+}
+
+// intParam parses the parameter name, which must lie between lo and hi.
+func intParam(q url.Values, name string, def, lo, hi int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be a whole number between %d and %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// find returns the benches of s, closest first.
+func (s *Server) find(ctx context.Context, q search) ([]bench.Bench, error) {
+	benches, err := s.cfg.Store.FindNearbyWithDetails(ctx, q.Lat, q.Lon, q.Radius)
+	if err != nil || q.Community {
+		return benches, err
+	}
+	city := benches[:0]
+	for _, b := range benches {
+		if !b.Community {
+			city = append(city, b)
+		}
+	}
+	return city, nil
+}
+
+// nearbyHandler lists the benches around a location, a page at a time, as
+// JSON or GeoJSON.
+func (s *Server) nearbyHandler(w http.ResponseWriter, r *http.Request) {
+	txn := newrelic.FromContext(r.Context())
+	segment := txn.StartSegment("api.nearby")
+	defer segment.End()
+
+	query := r.URL.Query()
+	q, err := parseSearch(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := intParam(query, "limit", defaultLimit, 1, maxLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := intParam(query, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	geoJSON, err := wantsGeoJSON(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	benches, err := s.find(r.Context(), q)
+	if err != nil {
+		internalError(w, r, "finding benches", err)
+		return
+	}
+
+	p := page{Total: len(benches), Limit: limit, Offset: offset}
+	benches = benches[min(offset, len(benches)):min(offset+limit, len(benches))]
+	if offset+limit < p.Total {
+		next := *r.URL
+		query.Set("offset", strconv.Itoa(offset+limit))
+		next.RawQuery = query.Encode()
+		p.Next = next.RequestURI()
+	}
+
+	if geoJSON {
+		writeJSON(w, bench.ExportGeoJSON.ContentType(), nearbyFeatures{
+			FeatureCollection: bench.NewFeatureCollection(benches),
+			page:              p,
+		})
+		return
+	}
+	resp := nearbyResponse{page: p, Benches: make([]benchJSON, len(benches))}
+	for i, b := range benches {
+		resp.Benches[i] = newBenchJSON(b)
+	}
+	writeJSON(w, "application/json", resp)
+}
+
+// wantsGeoJSON tells whether the client asked for GeoJSON, by the format
+// parameter or else by the Accept header.
+func wantsGeoJSON(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return false, nil
+	case "geojson":
+		return true, nil
+	case "":
+		return strings.Contains(r.Header.Get("Accept"), bench.ExportGeoJSON.ContentType()), nil
+	}
+	return false, fmt.Errorf("format must be json or geojson")
+}
+
+// benchHandler returns a bench by its GIS ID.
+func (s *Server) benchHandler(w http.ResponseWriter, r *http.Request) {
+	txn := newrelic.FromContext(r.Context())
+	segment := txn.StartSegment("api.bench")
+	defer segment.End()
+
+	gisID := r.PathValue("gis_id")
+	b, err := s.cfg.Store.GetBenchByID(r.Context(), gisID)
+	if err != nil {
+		internalError(w, r, "getting bench", err)
+		return
+	}
+	writeJSON(w, "application/json", newBenchJSON(*b))
+}
+
+// mapHandler draws the benches around a location, as the bot does.
+func (s *Server) mapHandler(w http.ResponseWriter, r *http.Request) {
+	txn := newrelic.FromContext(r.Context())
+	segment := txn.StartSegment("api.map")
+	defer segment.End()
+
+	query := r.URL.Query()
+	q, err := parseSearch(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req := maps.Request{Lat: q.Lat, Lon: q.Lon, Radius: q.Radius, Style: maps.StyleStandard}
+	if v := query.Get("style"); v != "" {
+		if req.Style = maps.Style(v); !req.Style.Valid() {
+			writeError(w, http.StatusBadRequest, "unknown style "+strconv.Quote(v))
+			return
+		}
+	}
+	theme := query.Get("theme")
+	if theme != "" && !s.cfg.Themes.Has(theme) {
+		writeError(w, http.StatusBadRequest, "unknown theme "+strconv.Quote(theme))
+		return
+	}
+	req.Theme = s.cfg.Themes.Get(cmp.Or(theme, maps.ThemeDefault))
+	locale := i18n.Default
+	if v := query.Get("lang"); v != "" {
+		var ok bool
+		if locale, ok = i18n.Parse(v); !ok {
+			writeError(w, http.StatusBadRequest, "unsupported lang "+strconv.Quote(v))
+			return
+		}
+	}
+	if req.Width, err = intParam(query, "width", 0, minMapSize, maxMapSize); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Height, err = intParam(query, "height", 0, minMapSize, maxMapSize); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Zoom, err = intParam(query, "zoom", 0, maps.MinZoom, maps.MaxZoom); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Benches, err = s.find(r.Context(), q); err != nil {
+		internalError(w, r, "finding benches", err)
+		return
+	}
+	req.Legend = legend(locale)
+	req.RadiusLabel = settings.Settings{Units: settings.Metric}.FormatDistance(q.Radius)
+
+	// Maps take a lot of memory to draw, so only a few are drawn at once.
+	if s.cfg.RenderWorker != nil {
+		release, ok := s.cfg.RenderWorker()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "too many maps being drawn")
+			return
+		}
+		defer release()
+	}
+	img, err := s.cfg.Renderer.RenderBytes(r.Context(), req)
+	if err != nil {
+		internalError(w, r, "generating map", err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+	w.Write(img)
+}
+
+// legend translates the legend drawn on maps.
+func legend(l i18n.Locale) maps.Legend {
+	return maps.Legend{
+		You:       i18n.T(l, "map.legend.you"),
+		Nearest:   i18n.T(l, "map.legend.nearest"),
+		Bench:     i18n.T(l, "map.legend.bench"),
+		Community: i18n.T(l, "map.legend.community"),
+		Shaded:    i18n.T(l, "map.legend.shaded"),
+		Cluster:   i18n.T(l, "map.legend.cluster"),
+	}
+}
@@ -2,11 +2,21 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+// Where rendered maps are cached, see Config.RenderCache.
+const (
+	RenderCacheRedis = "redis"
+	RenderCacheDisk  = "disk"
+	RenderCacheOff   = "off"
 )
 
 type Config struct {
@@ -24,30 +34,129 @@ type Config struct {
 	NewRelicLicenseKey string `json:"new_relic_license_key"`
 	NewRelicAppName    string `json:"new_relic_app_name"`
 
+	// Webhook settings. Without a WebhookURL the bot uses long polling.
+	WebhookURL          string `json:"webhook_url"`
+	WebhookListenAddr   string `json:"webhook_listen_addr"`
+	WebhookSecret       string `json:"webhook_secret"`
+	WebhookDeleteOnStop bool   `json:"webhook_delete_on_stop"`
+
+	// API settings. Without an APIListenAddr the HTTP API is not served.
+	// APITrustedProxies lists the addresses or CIDR ranges, separated by ",",
+	// of the proxies whose X-Forwarded-For header names the client.
+	APIListenAddr     string `json:"api_listen_addr"`
+	APITrustedProxies string `json:"api_trusted_proxies"`
+
+	// Update queue settings
+	RenderWorkers       int `json:"render_workers"`
+	TextWorkers         int `json:"text_workers"`
+	UpdateQueueCapacity int `json:"update_queue_capacity"`
+
+	// Rate limit settings. User and render limits are per minute, the global
+	// limit is per second.
+	UserRateLimit   int `json:"user_rate_limit"`
+	UserRateBurst   int `json:"user_rate_burst"`
+	RenderRateLimit int `json:"render_rate_limit"`
+	RenderRateBurst int `json:"render_rate_burst"`
+	GlobalRateLimit int `json:"global_rate_limit"`
+	BanStrikes      int `json:"ban_strikes"`
+	BanMinutes      int `json:"ban_minutes"`
+
+	// Map settings. MapJPEGQuality only applies when MapFormat is "jpeg".
+	// MapThemesFile optionally adds themes to the built-in ones, see
+	// maps.LoadThemes.
+	MapFormat      string `json:"map_format"`
+	MapJPEGQuality int    `json:"map_jpeg_quality"`
+	MapThemesFile  string `json:"map_themes_file"`
+
+	// Tile settings. TileSources lists "<style>=<source>" entries separated by
+	// ";", tried in order before the style's public tile server. TileOffline
+	// only draws the public server's tiles that are already cached. Sources
+	// are described in tiles.Open.
+	TileSources       string `json:"tile_sources"`
+	TileOffline       bool   `json:"tile_offline"`
+	TileUserAgent     string `json:"tile_user_agent"`
+	TileCacheDir      string `json:"tile_cache_dir"`
+	TileCacheMaxMB    int    `json:"tile_cache_max_mb"`
+	TileCacheTTLHours int    `json:"tile_cache_ttl_hours"`
+
+	// Render cache settings. RenderCache is "redis", "disk" or "off". Maps
+	// are cached per cell of RenderCacheCellMetres so that nearby searches
+	// share a map.
+	RenderCache           string `json:"render_cache"`
+	RenderCacheDir        string `json:"render_cache_dir"`
+	RenderCacheTTLMinutes int    `json:"render_cache_ttl_minutes"`
+	RenderCacheCellMetres int    `json:"render_cache_cell_metres"`
+
+	// Shade settings
+	ShadeDatasetPath string `json:"shade_dataset_path"`
+
 	// Other settings
 	Environment string `json:"environment"`
 }
 
 func LoadConfig() (*Config, error) {
-	godotenv.Load()
-
-	config := &Config{
-		TelegramToken:      os.Getenv("TELEGRAM_BOT_TOKEN"),
-		AdminUserID:        getEnvAsInt64("ADMIN_USER_ID", 0),
-		BenchesDatasetURL:  getEnvOrDefault("BENCHES_DATASET_URL", "https://opendata-ajuntament.barcelona.cat/resources/bcn/Mobiliari_Urba/Infraestruc_Mobiliari_Urba_Bancs.json"),
-		RedisAddr:          getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:      os.Getenv("REDIS_PASSWORD"),
-		RedisDB:            getEnvAsInt("REDIS_DB", 0),
-		NewRelicLicenseKey: os.Getenv("NEW_RELIC_LICENSE_KEY"),
-		NewRelicAppName:    getEnvOrDefault("NEW_RELIC_APP_NAME", "Where is my bench bot"),
-		Environment:        getEnvOrDefault("ENVIRONMENT", "production"),
-	}
+	config := load()
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
+	return config, nil
+}
 
+// LoadToolConfig loads the config of the command line tools. They do not talk
+// to Telegram, so only the map and tile settings are validated.
+func LoadToolConfig() (*Config, error) {
+	config := load()
+	if err := config.validateMaps(); err != nil {
+		return nil, err
+	}
 	return config, nil
+}
+
+func load() *Config {
+	godotenv.Load()
 
+	config := &Config{
+		TelegramToken:         os.Getenv("TELEGRAM_BOT_TOKEN"),
+		AdminUserID:           getEnvAsInt64("ADMIN_USER_ID", 0),
+		BenchesDatasetURL:     getEnvOrDefault("BENCHES_DATASET_URL", "https://opendata-ajuntament.barcelona.cat/resources/bcn/Mobiliari_Urba/Infraestruc_Mobiliari_Urba_Bancs.json"),
+		RedisAddr:             getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:         os.Getenv("REDIS_PASSWORD"),
+		RedisDB:               getEnvAsInt("REDIS_DB", 0),
+		NewRelicLicenseKey:    os.Getenv("NEW_RELIC_LICENSE_KEY"),
+		NewRelicAppName:       getEnvOrDefault("NEW_RELIC_APP_NAME", "Where is my bench bot"),
+		WebhookURL:            os.Getenv("WEBHOOK_URL"),
+		WebhookListenAddr:     getEnvOrDefault("WEBHOOK_LISTEN_ADDR", ":8080"),
+		WebhookSecret:         os.Getenv("WEBHOOK_SECRET"),
+		WebhookDeleteOnStop:   getEnvAsBool("WEBHOOK_DELETE_ON_STOP", true),
+		APIListenAddr:         os.Getenv("API_LISTEN_ADDR"),
+		APITrustedProxies:     os.Getenv("API_TRUSTED_PROXIES"),
+		RenderWorkers:         getEnvAsInt("RENDER_WORKERS", 2),
+		TextWorkers:           getEnvAsInt("TEXT_WORKERS", 8),
+		UpdateQueueCapacity:   getEnvAsInt("UPDATE_QUEUE_CAPACITY", 100),
+		UserRateLimit:         getEnvAsInt("USER_RATE_LIMIT", 60),
+		UserRateBurst:         getEnvAsInt("USER_RATE_BURST", 10),
+		RenderRateLimit:       getEnvAsInt("RENDER_RATE_LIMIT", 6),
+		RenderRateBurst:       getEnvAsInt("RENDER_RATE_BURST", 3),
+		GlobalRateLimit:       getEnvAsInt("GLOBAL_RATE_LIMIT", 25),
+		BanStrikes:            getEnvAsInt("BAN_STRIKES", 20),
+		BanMinutes:            getEnvAsInt("BAN_MINUTES", 15),
+		MapFormat:             getEnvOrDefault("MAP_FORMAT", string(maps.FormatPNG)),
+		MapJPEGQuality:        getEnvAsInt("MAP_JPEG_QUALITY", 85),
+		MapThemesFile:         os.Getenv("MAP_THEMES_FILE"),
+		TileSources:           os.Getenv("TILE_SOURCES"),
+		TileOffline:           getEnvAsBool("TILE_OFFLINE", false),
+		TileUserAgent:         getEnvOrDefault("TILE_USER_AGENT", maps.DefaultUserAgent),
+		TileCacheDir:          getEnvOrDefault("TILE_CACHE_DIR", filepath.Join(os.TempDir(), "where-is-my-bench-tiles")),
+		TileCacheMaxMB:        getEnvAsInt("TILE_CACHE_MAX_MB", 512),
+		TileCacheTTLHours:     getEnvAsInt("TILE_CACHE_TTL_HOURS", 7*24),
+		RenderCache:           getEnvOrDefault("RENDER_CACHE", RenderCacheRedis),
+		RenderCacheDir:        getEnvOrDefault("RENDER_CACHE_DIR", filepath.Join(os.TempDir(), "where-is-my-bench-renders")),
+		RenderCacheTTLMinutes: getEnvAsInt("RENDER_CACHE_TTL_MINUTES", 60),
+		RenderCacheCellMetres: getEnvAsInt("RENDER_CACHE_CELL_METRES", 25),
+		ShadeDatasetPath:      os.Getenv("SHADE_DATASET_PATH"),
+		Environment:           getEnvOrDefault("ENVIRONMENT", "production"),
+	}
+	return config
 }
 
 func (c *Config) Validate() error {
@@ -57,12 +166,111 @@ func (c *Config) Validate() error {
 		missingVars = append(missingVars, "TELEGRAM_BOT_TOKEN")
 	}
 
+	if c.WebhookURL != "" && c.WebhookSecret == "" {
+		missingVars = append(missingVars, "WEBHOOK_SECRET")
+	}
+
 	if len(missingVars) > 0 {
 		return fmt.Errorf("missing required environment variables: %v", strings.Join(missingVars, ", "))
 	}
 
+	if c.WebhookURL != "" && !strings.HasPrefix(c.WebhookURL, "https://") {
+		return fmt.Errorf("WEBHOOK_URL must be an https URL")
+	}
+	if !validSecretToken(c.WebhookSecret) {
+		return fmt.Errorf("WEBHOOK_SECRET must be at most 256 letters, digits, _ or -")
+	}
+
+	if c.APIListenAddr != "" && c.WebhookURL != "" && c.APIListenAddr == c.WebhookListenAddr {
+		return fmt.Errorf("API_LISTEN_ADDR must differ from WEBHOOK_LISTEN_ADDR")
+	}
+	if _, err := c.TrustedProxies(); err != nil {
+		return err
+	}
+
+	if c.UserRateLimit <= 0 || c.UserRateBurst <= 0 || c.RenderRateLimit <= 0 || c.RenderRateBurst <= 0 || c.GlobalRateLimit <= 0 {
+		return fmt.Errorf("rate limits and bursts must be positive")
+	}
+	if err := c.validateMaps(); err != nil {
+		return err
+	}
+	switch c.RenderCache {
+	case RenderCacheRedis, RenderCacheDisk, RenderCacheOff:
+	default:
+		return fmt.Errorf("RENDER_CACHE must be redis, disk or off")
+	}
+	if c.RenderCacheTTLMinutes <= 0 || c.RenderCacheCellMetres <= 0 {
+		return fmt.Errorf("RENDER_CACHE_TTL_MINUTES and RENDER_CACHE_CELL_METRES must be positive")
+	}
+	if c.BanStrikes < 0 || (c.BanStrikes > 0 && c.BanMinutes <= 0) {
+		return fmt.Errorf("BAN_STRIKES must not be negative, and BAN_MINUTES must be positive when bans are enabled")
+	}
+
+	return nil
+}
+
+// validateMaps checks the settings used to render maps and fetch their tiles.
+func (c *Config) validateMaps() error {
+	if !maps.Format(c.MapFormat).Valid() {
+		return fmt.Errorf("MAP_FORMAT must be png or jpeg")
+	}
+	if c.MapJPEGQuality < 1 || c.MapJPEGQuality > 100 {
+		return fmt.Errorf("MAP_JPEG_QUALITY must be between 1 and 100")
+	}
+	if _, err := c.TileSourceSpecs(); err != nil {
+		return err
+	}
+	if c.TileCacheMaxMB < 0 || c.TileCacheTTLHours <= 0 {
+		return fmt.Errorf("TILE_CACHE_MAX_MB must not be negative and TILE_CACHE_TTL_HOURS must be positive")
+	}
 	return nil
 }
+
+// MapEncoding is how rendered maps are encoded.
+func (c *Config) MapEncoding() maps.Encoding {
+	return maps.Encoding{Format: maps.Format(c.MapFormat), Quality: c.MapJPEGQuality}
+}
+
+// TrustedProxies parses APITrustedProxies. Single addresses are returned as
+// prefixes of their full length.
+func (c *Config) TrustedProxies() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(c.APITrustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("API_TRUSTED_PROXIES entry %q must be an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// TileSourceSpecs parses TileSources into the source specs of each style, in
+// the order they are tried.
+func (c *Config) TileSourceSpecs() (map[maps.Style][]string, error) {
+	specs := make(map[maps.Style][]string)
+	for _, entry := range strings.Split(c.TileSources, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		style, spec, ok := strings.Cut(entry, "=")
+		if !ok || !maps.Style(style).Valid() || spec == "" {
+			return nil, fmt.Errorf("TILE_SOURCES entry %q must be <style>=<source> with a known style", entry)
+		}
+		specs[maps.Style(style)] = append(specs[maps.Style(style)], spec)
+	}
+	return specs, nil
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -88,3 +296,25 @@ func getEnvAsInt64(key string, defaultValue int) int64 {
 	}
 	return int64(defaultValue)
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// validSecretToken checks the charset Telegram accepts for webhook secrets.
+func validSecretToken(token string) bool {
+	if len(token) > 256 {
+		return false
+	}
+	for _, r := range token {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
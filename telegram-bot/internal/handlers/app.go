@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/api"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/downloader"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/queue"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/rendercache"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/router"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage/redis"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/tilesets"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/shade"
+)
+
+// App holds the dependencies shared by every handler. It is built once at
+// startup and closed on shutdown.
+type App struct {
+	cfg        *config.Config
+	store      *redis.BenchStore
+	downloader *downloader.Downloader
+	renderer   *maps.Renderer
+	themes     *maps.Themes
+	tiles      *tilesets.Sets
+	// renders is nil when the render cache is off.
+	renders *rendercache.Cache
+	// nrApp may be nil when New Relic is not configured.
+	nrApp  *newrelic.Application
+	router *router.Router
+	queue  *queue.Queue
+	limits limits
+
+	shadeOnce  sync.Once
+	shadeIndex *shade.Index
+}
+
+func NewApp(cfg *config.Config, nrApp *newrelic.Application) (*App, error) {
+	themes, err := maps.LoadThemes(cfg.MapThemesFile)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := tilesets.Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := append(sets.RendererOptions(), maps.WithEncoding(cfg.MapEncoding()))
+
+	a := &App{
+		cfg:        cfg,
+		store:      redis.NewBenchStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB),
+		downloader: downloader.NewDownloader(cfg.BenchesDatasetURL),
+		renderer:   maps.NewRenderer(opts...),
+		themes:     themes,
+		tiles:      sets,
+		nrApp:      nrApp,
+	}
+	switch cfg.RenderCache {
+	case config.RenderCacheRedis:
+		a.renders = newRenderCache(cfg, a.store)
+	case config.RenderCacheDisk:
+		disk, err := rendercache.NewDisk(cfg.RenderCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		a.renders = newRenderCache(cfg, disk)
+	}
+	a.limits = newLimits(cfg, a.store)
+	a.queue = queue.New(queue.Config{
+		Workers: map[queue.Class]int{
+			queue.ClassRender: cfg.RenderWorkers,
+			queue.ClassText:   cfg.TextWorkers,
+		},
+		Capacity: cfg.UpdateQueueCapacity,
+	}, updateClass, a.Handle, nrApp)
+	return a, nil
+}
+
+// APIHandler returns the HTTP API, backed by the same store and tiles as the
+// bot. Its maps are always PNG.
+func (a *App) APIHandler() (http.Handler, error) {
+	proxies, err := a.cfg.TrustedProxies()
+	if err != nil {
+		return nil, err
+	}
+	opts := append(a.tiles.RendererOptions(), maps.WithEncoding(maps.DefaultEncoding))
+	return api.New(api.Config{
+		Store:          a.store,
+		Renderer:       maps.NewRenderer(opts...),
+		Themes:         a.themes,
+		Global:         a.limits.global,
+		Requests:       a.limits.user,
+		Renders:        a.limits.render,
+		RenderWorker:   func() (func(), bool) { return a.queue.TryWorker(queue.ClassRender) },
+		NewRelic:       a.nrApp,
+		TrustedProxies: proxies,
+	}), nil
+}
+
+// Enqueue queues an update to be handled by Handle. It blocks while the queue
+// is full.
+func (a *App) Enqueue(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if !a.queue.Submit(ctx, b, update) {
+		log.Printf("dropped update %d: %v", update.ID, ctx.Err())
+	}
+}
+
+// Handle serves an update in its own New Relic transaction. Routes must be
+// registered first.
+func (a *App) Handle(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := a.nrApp.StartTransaction("bot_update")
+	defer txn.End()
+	txn.AddAttribute("queue_wait_ms", queue.WaitFromContext(ctx).Milliseconds())
+	a.router.Handle(newrelic.NewContext(ctx, txn), b, update)
+}
+
+// updateClass puts the updates that render a map in their own class, so
+// that a burst of them cannot hold up text replies.
+func updateClass(update *models.Update) queue.Class {
+	switch {
+	case update.Message != nil && update.Message.Location != nil:
+		return queue.ClassRender
+	case update.Message != nil && strings.HasPrefix(update.Message.Text, "/heatmap"):
+		return queue.ClassRender
+	case update.CallbackQuery != nil &&
+		(strings.HasPrefix(update.CallbackQuery.Data, bestCallbackPrefix) ||
+			strings.HasPrefix(update.CallbackQuery.Data, shadeCallbackPrefix)):
+		return queue.ClassRender
+	}
+	return queue.ClassText
+}
+
+// Close waits for queued updates to be handled, then releases the
+// connections held by the app.
+func (a *App) Close(ctx context.Context) error {
+	if err := a.queue.Wait(ctx); err != nil {
+		log.Printf("error waiting for queued updates: %v", err)
+	}
+	if err := a.tiles.Close(); err != nil {
+		log.Printf("error closing tile sources: %v", err)
+	}
+	return a.store.Close()
+}
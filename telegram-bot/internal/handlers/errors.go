@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+)
+
+// Error classes reported to New Relic, so that failures can be grouped by
+// cause rather than by message.
+const (
+	errClassNotFound    = "not_found"
+	errClassConflict    = "conflict"
+	errClassUnavailable = "storage_unavailable"
+	errClassTimeout     = "timeout"
+	errClassTelegram    = "telegram"
+	errClassPanic       = "panic"
+	errClassInternal    = "internal"
+)
+
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return errClassNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return errClassConflict
+	case errors.Is(err, storage.ErrUnavailable):
+		return errClassUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return errClassTimeout
+	case errors.Is(err, bot.ErrorForbidden), errors.Is(err, bot.ErrorBadRequest),
+		errors.Is(err, bot.ErrorTooManyRequests), errors.Is(err, bot.ErrorNotFound):
+		return errClassTelegram
+	}
+	return errClassInternal
+}
+
+// noticeError logs err and reports it to New Relic with its class.
+func noticeError(ctx context.Context, op string, err error) {
+	class := classifyError(err)
+	newrelic.FromContext(ctx).NoticeError(newrelic.Error{
+		Message:    err.Error(),
+		Class:      class,
+		Attributes: map[string]interface{}{"operation": op},
+	})
+	log.Printf("error %s (%s): %v", op, class, err)
+}
+
+// replyError reports err and apologises to the user, so that a failure is
+// never met with silence.
+func replyError(ctx context.Context, b *bot.Bot, update *models.Update, op string, err error) {
+	noticeError(ctx, op, err)
+	apologize(ctx, b, update, classifyError(err))
+}
+
+// apologize tells the user their request failed. Callback queries get the
+// apology as a notification unless they were already answered.
+func apologize(ctx context.Context, b *bot.Bot, update *models.Update, class string) {
+	text := tr(ctx, "common.error")
+	switch class {
+	case errClassUnavailable, errClassTimeout:
+		text = tr(ctx, "common.unavailable")
+	case errClassNotFound:
+		text = tr(ctx, "common.not_found")
+	}
+
+	var chatID int64
+	switch {
+	case update.Message != nil:
+		chatID = update.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		if err := answerCallbackQuery(ctx, b, update.CallbackQuery.ID, text); err == nil {
+			return
+		}
+		chatID = callbackChatID(update.CallbackQuery)
+	default:
+		return
+	}
+
+	if err := sendMessage(ctx, b, chatID, text); err != nil {
+		log.Printf("error sending apology: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/router"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	exportCallbackPrefix       = "export:"
+	exportFormatCallbackPrefix = "export_fmt:"
+)
+
+// exportFormatLabels names the export formats on buttons. Format names are
+// the same in every language.
+var exportFormatLabels = map[bench.ExportFormat]string{
+	bench.ExportGeoJSON: "GeoJSON",
+	bench.ExportGPX:     "GPX",
+	bench.ExportKML:     "KML",
+}
+
+// exportHandler sends the benches of the user's last search as a file, in
+// the format given as argument, or asks for one.
+func (a *App) exportHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.export")
+	defer segment.End()
+
+	if update.Message.From == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	cmd, _ := router.CommandFromContext(ctx)
+
+	lat, lon, ok, err := a.store.GetLastSearch(ctx, update.Message.From.ID)
+	if err != nil {
+		replyError(ctx, b, update, "getting last search", err)
+		return
+	}
+	if !ok {
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "export.no_search")); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return
+	}
+
+	if cmd.Args == "" {
+		a.sendExportPrompt(ctx, b, chatID, lat, lon)
+		return
+	}
+	format := bench.ExportFormat(strings.ToLower(cmd.Args))
+	if !format.Valid() {
+		names := make([]string, len(bench.ExportFormats))
+		for i, f := range bench.ExportFormats {
+			names[i] = string(f)
+		}
+		text := tr(ctx, "export.unknown_format", cmd.Args, strings.Join(names, ", "))
+		if err := sendMessage(ctx, b, chatID, text); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return
+	}
+	a.sendExport(ctx, b, update, chatID, format, lat, lon)
+}
+
+// exportCallback asks for the format to export the benches of a previous
+// result in.
+func (a *App) exportCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.export_prompt")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	lat, lon, ok := parseLocation(strings.TrimPrefix(query.Data, exportCallbackPrefix))
+	if !ok {
+		log.Printf("malformed export callback data: %s", query.Data)
+		return
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+	a.sendExportPrompt(ctx, b, callbackChatID(query), lat, lon)
+}
+
+// exportFormatCallback sends the benches of a previous result as a file in
+// the chosen format.
+func (a *App) exportFormatCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.export_format")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	formatStr, location, _ := strings.Cut(strings.TrimPrefix(query.Data, exportFormatCallbackPrefix), ":")
+	format := bench.ExportFormat(formatStr)
+	lat, lon, ok := parseLocation(location)
+	if !ok || !format.Valid() {
+		log.Printf("malformed export format callback data: %s", query.Data)
+		return
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+	a.sendExport(ctx, b, update, callbackChatID(query), format, lat, lon)
+}
+
+func (a *App) sendExportPrompt(ctx context.Context, b *bot.Bot, chatID int64, lat, lon float64) {
+	var row []models.InlineKeyboardButton
+	for _, f := range bench.ExportFormats {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         exportFormatLabels[f],
+			CallbackData: fmt.Sprintf("%s%s:%.5f,%.5f", exportFormatCallbackPrefix, f, lat, lon),
+		})
+	}
+	keyboard := [][]models.InlineKeyboardButton{row}
+	if err := sendMessageWithKeyboard(ctx, b, chatID, tr(ctx, "export.prompt"), keyboard); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// sendExport sends the benches around lat, lon as a document in format.
+func (a *App) sendExport(ctx context.Context, b *bot.Bot, update *models.Update, chatID int64, format bench.ExportFormat, lat, lon float64) {
+	txn := newrelic.FromContext(ctx)
+	txn.AddAttribute("export_format", string(format))
+	prefs := settings.FromContext(ctx)
+
+	benches, err := a.searchBenches(ctx, lat, lon)
+	if err != nil {
+		replyError(ctx, b, update, "finding benches", err)
+		return
+	}
+	if len(benches) == 0 {
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "export.empty")); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := bench.Export(&buf, format, benches); err != nil {
+		replyError(ctx, b, update, "exporting benches", err)
+		return
+	}
+	caption := trn(ctx, "export.caption", len(benches), len(benches), prefs.FormatDistance(prefs.Radius))
+	if err := sendDocument(ctx, b, chatID, "benches"+format.Extension(), buf.Bytes(), caption); err != nil {
+		noticeError(ctx, "sending export", err)
+	}
+}
+
+// parseLocation parses the "<lat>,<lon>" of callback data.
+func parseLocation(s string) (lat, lon float64, ok bool) {
+	latStr, lonStr, ok := strings.Cut(s, ",")
+	lat, latErr := strconv.ParseFloat(latStr, 64)
+	lon, lonErr := strconv.ParseFloat(lonStr, 64)
+	return lat, lon, ok && latErr == nil && lonErr == nil
+}
@@ -2,179 +2,418 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"github.com/newrelic/go-agent/v3/newrelic"
-	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
-	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/downloader"
-	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage/redis"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/router"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/shade"
 )
 
-const searchRadius float64 = 250
-
-func Handler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Printf("error loading config: %v", err)
-		return
-	}
-
-	switch {
-	case update.Message != nil && update.Message.Text == "/start":
-		startHandler(ctx, b, update)
-	case update.Message != nil && update.Message.Location != nil:
-		locationHandler(ctx, cfg, b, update)
-	case update.Message != nil && update.Message.Text == "/update_benches":
-		if !isAdmin(ctx, cfg.AdminUserID, update.Message.From.ID) {
-			log.Printf("unauthorized admin command received: %s\n %d not equal %d", update.Message.Text, cfg.AdminUserID, update.Message.From.ID)
-			err := sendMessage(ctx, b, update.Message.Chat.ID, "You are not authorized to perform this action.")
-			if err != nil {
-				log.Printf("error sending message: %v", err)
-			}
-			return
-		}
-		log.Printf("authorized admin command received: %s", update.Message.Text)
-		updateBenchesHandler(ctx, cfg, b, update)
-	}
-}
+const (
+	benchCallbackPrefix = "bench:"
+	bestCallbackPrefix  = "best:"
+	shadeCallbackPrefix = "shade:"
+)
 
 func startHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	txn := newrelic.FromContext(ctx)
 	segment := txn.StartSegment("command.start")
 	defer segment.End()
 
-	msg := "Hello! I'm a bot that can help you find your bench in Barcelona.\nJust send me your location and I'll do the rest. "
-	msgFmt := fmt.Sprintf("%s\n\n🏃‍♂️‍➡️🪑", msg)
-
 	txn.AddAttribute("message_type", "welcome")
-	err := sendMessage(ctx, b, update.Message.Chat.ID, msgFmt)
+	err := sendMessage(ctx, b, update.Message.Chat.ID, tr(ctx, "start.welcome"))
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error sending message: %v", err)
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+type rankMode int
+
+const (
+	rankByDistance rankMode = iota
+	// rankByScore blends distance and rating, for "best bench nearby" searches.
+	rankByScore
+	// rankByShade lists the benches estimated to be in the shade first.
+	rankByShade
+)
+
+func (a *App) locationHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	loc := update.Message.Location
+	a.nearbyHandler(ctx, b, update, loc.Latitude, loc.Longitude, rankByDistance)
+}
+
+// rerankCallback repeats a search from a previous result with a different ranking.
+func (a *App) rerankCallback(ctx context.Context, b *bot.Bot, update *models.Update, prefix string, rank rankMode) {
+	query := update.CallbackQuery
+	lat, lon, ok := parseLocation(strings.TrimPrefix(query.Data, prefix))
+	if !ok {
+		log.Printf("malformed rerank callback data: %s", query.Data)
+		return
 	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+	a.nearbyHandler(ctx, b, update, lat, lon, rank)
 }
 
-func locationHandler(ctx context.Context, cfg *config.Config, b *bot.Bot, update *models.Update) {
+// nearbyHandler replies to a location message, or to a button under a
+// previous result, with the benches around lat, lon.
+func (a *App) nearbyHandler(ctx context.Context, b *bot.Bot, update *models.Update, lat, lon float64, rank rankMode) {
 	txn := newrelic.FromContext(ctx)
 	segment := txn.StartSegment("command.location")
 	defer segment.End()
 
-	rdb := redis.NewBenchStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	var chatID int64
+	if update.Message != nil {
+		chatID = update.Message.Chat.ID
+	} else {
+		chatID = callbackChatID(update.CallbackQuery)
+	}
+
+	prefs := settings.FromContext(ctx)
 
-	benches, err := rdb.FindNearby(ctx, update.Message.Location.Latitude, update.Message.Location.Longitude, searchRadius)
+	if from := router.Sender(update); from != nil {
+		if err := a.store.SetLastSearch(ctx, from.ID, lat, lon); err != nil {
+			noticeError(ctx, "saving last search", err)
+		}
+	}
+
+	benchesNearby, err := a.searchBenches(ctx, lat, lon)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error finding benches: %v", err)
+		replyError(ctx, b, update, "finding benches", err)
 		return
 	}
 
-	benchesNearby := make([]bench.Bench, len(benches))
-	for i, b := range benches {
-		bench, err := rdb.GetBenchByID(ctx, b.GisID)
+	gisIDs := make([]string, len(benchesNearby))
+	for i, b := range benchesNearby {
+		gisIDs[i] = b.GisID
+	}
+
+	ratings, err := a.store.RatingSummaries(ctx, gisIDs)
+	if err != nil {
+		noticeError(ctx, "getting bench ratings", err)
+	}
+	if rank == rankByScore {
+		txn.AddAttribute("rank_mode", "score")
+		sort.SliceStable(benchesNearby, func(i, j int) bool {
+			return bench.BlendedScore(benchesNearby[i].Distance, prefs.Radius, ratings[benchesNearby[i].GisID]) >
+				bench.BlendedScore(benchesNearby[j].Distance, prefs.Radius, ratings[benchesNearby[j].GisID])
+		})
+	}
+
+	var shadeIdx *shade.Index
+	if prefs.HasLayer(settings.LayerShade) {
+		shadeIdx = a.loadShadeIndex(ctx)
+	}
+	if shadeIdx != nil {
+		now := time.Now()
+		for i := range benchesNearby {
+			benchesNearby[i].Shaded = shadeIdx.IsShaded(now, benchesNearby[i].Latitude, benchesNearby[i].Longitude)
+		}
+	}
+	if rank == rankByShade {
+		txn.AddAttribute("rank_mode", "shade")
+		sort.SliceStable(benchesNearby, func(i, j int) bool {
+			return benchesNearby[i].Shaded && !benchesNearby[j].Shaded
+		})
+	}
+
+	var reports map[string][]bench.Report
+	if prefs.HasLayer(settings.LayerReports) {
+		reports, err = a.store.OpenReportsByBench(ctx, gisIDs, time.Now().Add(-recentReportWindow))
 		if err != nil {
-			log.Fatalf("error getting bench by id: %v", err)
+			// Reports only affect ordering, so carry on without them.
+			noticeError(ctx, "getting bench reports", err)
 		}
-		benchesNearby[i] = *bench
+		benchesNearby = deRankReported(benchesNearby, reports)
 	}
 
-	mg := maps.NewMapGenerator
-	imgPath, err := mg().GenerateMap(ctx, update.Message.Location.Latitude, update.Message.Location.Longitude, searchRadius, benchesNearby)
+	listed := benchesNearby
+	if len(listed) > prefs.ResultCount {
+		listed = listed[:prefs.ResultCount]
+	}
+	radius := prefs.FormatDistance(prefs.Radius)
+
+	mapReq := maps.Request{
+		Lat:         lat,
+		Lon:         lon,
+		Radius:      prefs.Radius,
+		Benches:     benchesNearby,
+		Style:       prefs.MapStyle,
+		Numbered:    len(listed),
+		Legend:      mapLegend(ctx),
+		RadiusLabel: radius,
+		Theme:       a.themes.Get(prefs.Theme),
+	}
+	m, err := a.renderMap(ctx, mapReq)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error generating map: %v", err)
+		replyError(ctx, b, update, "generating map", err)
 		return
 	}
 
-	img, err := os.ReadFile(imgPath)
+	var sb strings.Builder
+	switch rank {
+	case rankByScore:
+		sb.WriteString(tr(ctx, "nearby.best", radius))
+	case rankByShade:
+		sb.WriteString(tr(ctx, "nearby.shade", radius))
+	default:
+		sb.WriteString(trn(ctx, "nearby.found", len(benchesNearby), len(benchesNearby), radius))
+	}
+	for i, bn := range listed {
+		fmt.Fprintf(&sb, "\n%d. %s · %s", i+1, bn.Address(), prefs.FormatDistance(bn.Distance))
+		if bn.Community {
+			sb.WriteString(" 👥")
+		}
+		if shadeIdx != nil {
+			if bn.Shaded {
+				sb.WriteString(" 🌳")
+			} else {
+				sb.WriteString(" ☀️")
+			}
+		}
+		if s, ok := ratings[bn.GisID]; ok {
+			fmt.Fprintf(&sb, " · %s", ratingText(ctx, s))
+		}
+		if r := reports[bn.GisID]; len(r) > 0 {
+			fmt.Fprintf(&sb, " %s", tr(ctx, "nearby.reported", reportSummary(ctx, r)))
+		}
+	}
+	if len(benchesNearby) > len(listed) {
+		more := len(benchesNearby) - len(listed)
+		fmt.Fprintf(&sb, "\n%s", trn(ctx, "nearby.more", more, more))
+	}
+	writeClusters(ctx, &sb, benchesNearby, a.renderer.Clusters(mapReq))
+	if hasCommunityBench(listed) {
+		fmt.Fprintf(&sb, "\n\n%s", tr(ctx, "nearby.community_legend"))
+	}
+	if shadeIdx != nil {
+		fmt.Fprintf(&sb, "\n%s", tr(ctx, "nearby.shade_legend"))
+	}
+	if len(listed) > 0 {
+		fmt.Fprintf(&sb, "\n\n%s", tr(ctx, "nearby.hint"))
+	}
+
+	keyboard := benchButtons(listed)
+	if rank == rankByDistance && len(benchesNearby) > 1 {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{
+			Text:         tr(ctx, "nearby.button_best"),
+			CallbackData: fmt.Sprintf("%s%.5f,%.5f", bestCallbackPrefix, lat, lon),
+		}})
+	}
+	if shadeIdx != nil && rank != rankByShade && len(benchesNearby) > 1 {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{
+			Text:         tr(ctx, "nearby.button_shade"),
+			CallbackData: fmt.Sprintf("%s%.5f,%.5f", shadeCallbackPrefix, lat, lon),
+		}})
+	}
+
+	if len(benchesNearby) > 0 {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{
+			Text:         tr(ctx, "nearby.button_export"),
+			CallbackData: fmt.Sprintf("%s%.5f,%.5f", exportCallbackPrefix, lat, lon),
+		}})
+	}
+
+	err = sendMessageWithKeyboard(ctx, b, chatID, sb.String(), keyboard)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error reading image file: %v", err)
+		noticeError(ctx, "sending message", err)
 		return
 	}
 
-	msg := fmt.Sprintf("I found %d benches 🪑 in a %.0f m radius near you:", len(benchesNearby), searchRadius)
-	err = sendMessage(ctx, b, update.Message.Chat.ID, msg)
+	err = a.sendMap(ctx, b, chatID, m)
+	if err != nil {
+		noticeError(ctx, "sending image", err)
+	}
+}
+
+// searchBenches returns the benches within the user's search radius of
+// lat, lon on the layers they enabled, closest first.
+func (a *App) searchBenches(ctx context.Context, lat, lon float64) ([]bench.Bench, error) {
+	prefs := settings.FromContext(ctx)
+	benches, err := a.store.FindNearbyWithDetails(ctx, lat, lon, prefs.Radius)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error sending message: %v", err)
+		return nil, err
+	}
+
+	benchesNearby := make([]bench.Bench, 0, len(benches))
+	for _, nb := range benches {
+		if nb.Community && !prefs.HasLayer(settings.LayerCommunity) {
+			continue
+		}
+		benchesNearby = append(benchesNearby, nb)
+	}
+	return benchesNearby, nil
+}
+
+// maxClustersListed caps the clusters described under the bench list.
+const maxClustersListed = 5
+
+// writeClusters describes the largest clusters drawn on the map, each by the
+// address of its bench closest to the user.
+func writeClusters(ctx context.Context, sb *strings.Builder, benches []bench.Bench, clusters []maps.Cluster) {
+	if len(clusters) == 0 {
 		return
 	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].Benches) > len(clusters[j].Benches)
+	})
 
-	err = sendImage(ctx, b, update.Message.Chat.ID, img)
+	fmt.Fprintf(sb, "\n\n%s", tr(ctx, "nearby.clusters"))
+	for _, c := range clusters[:min(len(clusters), maxClustersListed)] {
+		closest := benches[c.Benches[0]]
+		for _, i := range c.Benches[1:] {
+			if benches[i].Distance < closest.Distance {
+				closest = benches[i]
+			}
+		}
+		fmt.Fprintf(sb, "\n• %s", trn(ctx, "nearby.cluster", len(c.Benches), len(c.Benches), closest.Address()))
+	}
+	if more := len(clusters) - maxClustersListed; more > 0 {
+		fmt.Fprintf(sb, "\n%s", trn(ctx, "nearby.clusters_more", more, more))
+	}
+}
+
+// mapLegend translates the legend drawn on maps.
+func mapLegend(ctx context.Context) maps.Legend {
+	return maps.Legend{
+		You:       tr(ctx, "map.legend.you"),
+		Nearest:   tr(ctx, "map.legend.nearest"),
+		Bench:     tr(ctx, "map.legend.bench"),
+		Community: tr(ctx, "map.legend.community"),
+		Shaded:    tr(ctx, "map.legend.shaded"),
+		Cluster:   tr(ctx, "map.legend.cluster"),
+	}
+}
+
+func benchButtons(benches []bench.Bench) [][]models.InlineKeyboardButton {
+	const perRow = 5
+
+	var keyboard [][]models.InlineKeyboardButton
+	var row []models.InlineKeyboardButton
+	for i, b := range benches {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         strconv.Itoa(i + 1),
+			CallbackData: benchCallbackPrefix + b.GisID,
+		})
+		if len(row) == perRow {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		keyboard = append(keyboard, row)
+	}
+	return keyboard
+}
+
+// benchCallback shows a listed bench with the actions available for it.
+func (a *App) benchCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.bench")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	gisID := strings.TrimPrefix(query.Data, benchCallbackPrefix)
+
+	found, err := a.store.GetBenchByID(ctx, gisID)
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.bench_gone")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error sending image: %v", err)
+		replyError(ctx, b, update, "getting bench by id", err)
 		return
 	}
 
-	err = removeImage(ctx, imgPath)
+	ratings, err := a.store.RatingSummaries(ctx, []string{gisID})
+	if err != nil {
+		noticeError(ctx, "getting bench ratings", err)
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🪑 %s", found.Address())
+	if found.NeighborhoodName != "" {
+		fmt.Fprintf(&sb, "\n%s, %s", found.NeighborhoodName, found.DistrictName)
+	}
+	if description := benchDescription(ctx, *found); description != "" {
+		fmt.Fprintf(&sb, "\n%s", description)
+	}
+	fmt.Fprintf(&sb, "\n\n%s", tr(ctx, "bench.rating", ratingText(ctx, ratings[gisID])))
+
+	keyboard := [][]models.InlineKeyboardButton{{
+		{Text: tr(ctx, "bench.button_rate"), CallbackData: rateCallbackPrefix + gisID},
+		{Text: tr(ctx, "bench.button_report"), CallbackData: reportCallbackPrefix + gisID},
+	}}
+	err = sendMessageWithKeyboard(ctx, b, callbackChatID(query), sb.String(), keyboard)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error removing image: %v", err)
+		noticeError(ctx, "sending message", err)
 	}
 }
 
-func updateBenchesHandler(ctx context.Context, cfg *config.Config, b *bot.Bot, update *models.Update) {
+func (a *App) updateBenchesHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	txn := newrelic.FromContext(ctx)
 	segment := txn.StartSegment("command.location")
 	defer segment.End()
 
-	d := downloader.NewDownloader(cfg.BenchesDatasetURL)
-	data, err := d.DownloadJSON(ctx)
+	data, err := a.downloader.DownloadJSON(ctx)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error downloading JSON: %v", err)
+		replyError(ctx, b, update, "downloading JSON", err)
 		return
 	}
 
 	benches, err := bench.LoadBenches(ctx, data)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error loading benches: %v", err)
+		replyError(ctx, b, update, "loading benches", err)
 		return
 	}
 
 	if len(benches) == 0 {
-		log.Printf("no benches found in the dataset %s, skipping update", cfg.BenchesDatasetURL)
+		log.Printf("no benches found in the dataset %s, skipping update", a.cfg.BenchesDatasetURL)
 
-		msg := "No benches found in the dataset, skipping update."
-		err = sendMessage(ctx, b, update.Message.Chat.ID, msg)
+		err = sendMessage(ctx, b, update.Message.Chat.ID, tr(ctx, "update.empty"))
 		if err != nil {
-			txn.NoticeError(err)
-			log.Printf("error sending message: %v", err)
+			noticeError(ctx, "sending message", err)
 		}
 		return
 	}
 
 	log.Printf("Found %d benches, updating redis", len(benches))
 
-	rdb := redis.NewBenchStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
-
-	err = rdb.DeleteAllBenches(ctx)
+	err = a.store.DeleteAllBenches(ctx)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error deleting all benches: %v", err)
+		replyError(ctx, b, update, "deleting all benches", err)
 		return
 	}
 
-	err = rdb.StoreBenches(ctx, benches)
+	err = a.store.StoreBenches(ctx, benches)
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error storing benches: %v", err)
+		replyError(ctx, b, update, "storing benches", err)
 		return
 	}
 
-	msg := fmt.Sprintf("Successfully updated %d benches 🪑", len(benches))
-	err = sendMessage(ctx, b, update.Message.Chat.ID, msg)
+	err = sendMessage(ctx, b, update.Message.Chat.ID, trn(ctx, "update.done", len(benches), len(benches)))
 	if err != nil {
-		txn.NoticeError(err)
-		log.Printf("error sending message: %v", err)
+		noticeError(ctx, "sending message", err)
 		return
 	}
 }
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/router"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+// fewestListed is how many of the neighbourhoods with the fewest benches the
+// heatmap reply names.
+const fewestListed = 3
+
+// heatmapHandler sends a map of bench density: per neighbourhood for the
+// whole city, or per grid cell for the district given as argument.
+func (a *App) heatmapHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.heatmap")
+	defer segment.End()
+
+	chatID := update.Message.Chat.ID
+	cmd, _ := router.CommandFromContext(ctx)
+	prefs := settings.FromContext(ctx)
+
+	benches, err := a.store.AllBenches(ctx)
+	if err != nil {
+		replyError(ctx, b, update, "loading benches", err)
+		return
+	}
+	if len(benches) == 0 {
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "heatmap.empty")); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return
+	}
+
+	req := maps.HeatmapRequest{
+		Benches: benches,
+		Mode:    maps.HeatmapNeighborhood,
+		Style:   prefs.MapStyle,
+		Title:   tr(ctx, "heatmap.scale_neighborhood"),
+		Theme:   a.themes.Get(prefs.Theme),
+	}
+	text := tr(ctx, "heatmap.city", len(benches))
+	if cmd.Args != "" {
+		district, ok := bench.FindDistrict(benches, cmd.Args)
+		if !ok {
+			text := tr(ctx, "heatmap.unknown", cmd.Args, strings.Join(bench.Districts(benches), ", "))
+			if err := sendMessage(ctx, b, chatID, text); err != nil {
+				noticeError(ctx, "sending message", err)
+			}
+			return
+		}
+		txn.AddAttribute("district", district)
+		cell := prefs.FormatDistance(maps.DefaultCellSize)
+		req.Benches = bench.InDistrict(benches, district)
+		req.Mode = maps.HeatmapGrid
+		req.Title = tr(ctx, "heatmap.scale_grid", cell)
+		text = tr(ctx, "heatmap.district", district, len(req.Benches), cell)
+	}
+
+	img, err := a.renderer.HeatmapBytes(ctx, req)
+	if err != nil {
+		replyError(ctx, b, update, "generating heatmap", err)
+		return
+	}
+	if err := sendImage(ctx, b, chatID, "heatmap"+a.renderer.Encoding().Format.Extension(), img); err != nil {
+		noticeError(ctx, "sending heatmap", err)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(text)
+	if fewest := fewestNeighborhoods(req.Benches); len(fewest) > 0 {
+		fmt.Fprintf(&sb, "\n\n%s", tr(ctx, "heatmap.fewest"))
+		for _, c := range fewest {
+			fmt.Fprintf(&sb, "\n• %s", trn(ctx, "heatmap.neighborhood", c.Count, c.Name, c.Count))
+		}
+	}
+	if err := sendMessage(ctx, b, chatID, sb.String()); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// fewestNeighborhoods returns the neighbourhoods of benches with the fewest
+// benches, fewest first.
+func fewestNeighborhoods(benches []bench.Bench) []maps.HeatCell {
+	cells := maps.Heatmap(maps.HeatmapRequest{Benches: benches, Mode: maps.HeatmapNeighborhood})
+	slices.SortStableFunc(cells, func(a, b maps.HeatCell) int {
+		return cmp.Compare(a.Count, b.Count)
+	})
+	return cells[:min(len(cells), fewestListed)]
+}
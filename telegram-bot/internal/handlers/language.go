@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/i18n"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const languageCallbackPrefix = "lang:"
+
+// userLocale picks the language chosen in the user's settings, falling back
+// to the language of the user's Telegram client.
+func userLocale(prefs settings.Settings, from *models.User) i18n.Locale {
+	if l, ok := i18n.Parse(prefs.Language); ok {
+		return l
+	}
+	if from == nil {
+		return i18n.Default
+	}
+	return i18n.Detect(from.LanguageCode)
+}
+
+// adminLocale is the locale used for messages the bot sends to the admin on
+// its own initiative.
+func (a *App) adminLocale(ctx context.Context) i18n.Locale {
+	language, err := a.store.GetUserLanguage(ctx, a.cfg.AdminUserID)
+	if err != nil {
+		log.Printf("error getting admin language: %v", err)
+	}
+	return i18n.Detect(language)
+}
+
+func languageHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.language")
+	defer segment.End()
+
+	row := make([]models.InlineKeyboardButton, 0, len(i18n.Supported))
+	for _, l := range i18n.Supported {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         l.Name(),
+			CallbackData: languageCallbackPrefix + string(l),
+		})
+	}
+
+	err := sendMessageWithKeyboard(ctx, b, update.Message.Chat.ID, tr(ctx, "language.prompt"), [][]models.InlineKeyboardButton{row})
+	if err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+func (a *App) languageCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.language_selected")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	l, ok := i18n.Parse(strings.TrimPrefix(query.Data, languageCallbackPrefix))
+	if !ok {
+		log.Printf("unknown language in callback data: %s", query.Data)
+		return
+	}
+
+	if err := a.store.SetUserLanguage(ctx, query.From.ID, string(l)); err != nil {
+		noticeError(ctx, "setting user language", err)
+		return
+	}
+	txn.AddAttribute("language", string(l))
+
+	ctx = i18n.WithLocale(ctx, l)
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+	if err := editMessageWithKeyboard(ctx, b, query, tr(ctx, "language.saved"), nil); err != nil {
+		noticeError(ctx, "editing message", err)
+	}
+}
+
+// benchDescription translates the bench type and description when the
+// catalogue knows the dataset code, and falls back to the original Catalan
+// text otherwise.
+func benchDescription(ctx context.Context, b bench.Bench) string {
+	l := i18n.FromContext(ctx)
+	if text, ok := i18n.Lookup(l, "bench.code."+strings.ToLower(b.Code)); b.Code != "" && ok {
+		return text
+	}
+
+	benchType := b.Type
+	if text, ok := i18n.Lookup(l, "bench.type."+strings.ToLower(b.Type)); b.Type != "" && ok {
+		benchType = text
+	}
+
+	switch {
+	case benchType != "" && b.Description != "" && l != i18n.Catalan:
+		return benchType + " · " + b.Description
+	case b.Description != "":
+		return b.Description
+	default:
+		return benchType
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/ratelimit"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/router"
+)
+
+// limitNotice bounds how often a limited user is told so, so that replying
+// does not become the spam.
+var limitNotice = ratelimit.Policy{Name: "notice", Limit: ratelimit.Limit{Rate: 1.0 / 30, Burst: 1}}
+
+// limits are the rate limiters applied to updates.
+type limits struct {
+	user   *ratelimit.Limiter
+	render *ratelimit.Limiter
+	global *ratelimit.Limiter
+	// notices is kept per replica, a user seeing one notice per replica is
+	// fine.
+	notices *ratelimit.Memory
+}
+
+func newLimits(cfg *config.Config, store ratelimit.Store) limits {
+	fallback := ratelimit.NewMemory()
+	ban := func(name string, rate float64, burst int) ratelimit.Policy {
+		return ratelimit.Policy{
+			Name:         name,
+			Limit:        ratelimit.Limit{Rate: rate, Burst: burst},
+			Strikes:      int64(cfg.BanStrikes),
+			StrikeWindow: time.Minute,
+			BanDuration:  time.Duration(cfg.BanMinutes) * time.Minute,
+		}
+	}
+	return limits{
+		user:   ratelimit.New(store, fallback, ban("user", float64(cfg.UserRateLimit)/60, cfg.UserRateBurst)),
+		render: ratelimit.New(store, fallback, ban("render", float64(cfg.RenderRateLimit)/60, cfg.RenderRateBurst)),
+		global: ratelimit.New(store, fallback, ratelimit.Policy{
+			Name:  "global",
+			Limit: ratelimit.Limit{Rate: float64(cfg.GlobalRateLimit), Burst: 2 * cfg.GlobalRateLimit},
+		}),
+		notices: ratelimit.NewMemory(),
+	}
+}
+
+// userLimitKey limits updates per sender, leaving the admin unlimited.
+func (a *App) userLimitKey(ctx context.Context, update *models.Update) string {
+	if from := router.Sender(update); from != nil && from.ID == a.cfg.AdminUserID {
+		return ""
+	}
+	return router.SenderKey(ctx, update)
+}
+
+// globalLimitKey puts every update but the admin's in one bucket.
+func (a *App) globalLimitKey(ctx context.Context, update *models.Update) string {
+	if a.userLimitKey(ctx, update) == "" {
+		return ""
+	}
+	return "global"
+}
+
+// userLimited tells the sender to slow down, or that they are banned.
+func (a *App) userLimited(ctx context.Context, b *bot.Bot, update *models.Update, retryAfter time.Duration) {
+	a.limitedNotice(ctx, b, update, func(ctx context.Context, key string) string {
+		if status, err := a.limits.user.Status(ctx, key); err == nil && status.BanTTL > 0 {
+			return tr(ctx, "ratelimit.banned", formatWait(status.BanTTL))
+		}
+		return tr(ctx, "ratelimit.slow_down", formatWait(retryAfter))
+	})
+}
+
+// renderLimited tells the sender to wait before asking for another map.
+func (a *App) renderLimited(ctx context.Context, b *bot.Bot, update *models.Update, retryAfter time.Duration) {
+	a.limitedNotice(ctx, b, update, func(ctx context.Context, _ string) string {
+		return tr(ctx, "ratelimit.slow_down", formatWait(retryAfter))
+	})
+}
+
+// globalLimited tells the sender the bot is too busy.
+func (a *App) globalLimited(ctx context.Context, b *bot.Bot, update *models.Update, retryAfter time.Duration) {
+	a.limitedNotice(ctx, b, update, func(ctx context.Context, _ string) string {
+		return tr(ctx, "ratelimit.busy", formatWait(retryAfter))
+	})
+}
+
+// limitedNotice sends the text built by notice, at most once per
+// limitNotice. Limited updates never load the user's settings, so the text is
+// in the language of their client.
+func (a *App) limitedNotice(ctx context.Context, b *bot.Bot, update *models.Update, notice func(ctx context.Context, key string) string) {
+	key := router.SenderKey(ctx, update)
+	if res, _ := a.limits.notices.Take(ctx, key, limitNotice); !res.Allowed {
+		return
+	}
+	ctx = clientLocale(ctx, update)
+	text := notice(ctx, key)
+
+	var chatID int64
+	switch {
+	case update.Message != nil:
+		chatID = update.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		if err := answerCallbackQuery(ctx, b, update.CallbackQuery.ID, text); err == nil {
+			return
+		}
+		chatID = callbackChatID(update.CallbackQuery)
+	default:
+		return
+	}
+
+	if err := sendMessage(ctx, b, chatID, text); err != nil {
+		log.Printf("error sending rate limit notice: %v", err)
+	}
+}
+
+// formatWait rounds d up to whole seconds or minutes.
+func formatWait(d time.Duration) string {
+	if d <= time.Minute {
+		return fmt.Sprintf("%d s", max(1, int((d+time.Second-1)/time.Second)))
+	}
+	return fmt.Sprintf("%d min", int((d+time.Minute-1)/time.Minute))
+}
+
+// limitsHandler shows the banned users with "/limits", a user's limits with
+// "/limits <user_id>", and lifts them with "/limits <user_id> lift".
+func (a *App) limitsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.limits")
+	defer segment.End()
+
+	chatID := update.Message.Chat.ID
+	cmd, _ := router.CommandFromContext(ctx)
+	args := strings.Fields(cmd.Args)
+
+	var text string
+	switch {
+	case len(args) == 0:
+		bans, err := a.limits.user.Bans(ctx)
+		if err != nil {
+			replyError(ctx, b, update, "listing bans", err)
+			return
+		}
+		text = tr(ctx, "limits.none")
+		if len(bans) > 0 {
+			var sb strings.Builder
+			sb.WriteString(tr(ctx, "limits.bans"))
+			for _, ban := range bans {
+				sb.WriteString("\n" + tr(ctx, "limits.ban", strings.TrimPrefix(ban.Key, "user:"), formatWait(ban.TTL)))
+			}
+			text = sb.String()
+		}
+	case len(args) <= 2:
+		userID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || (len(args) == 2 && args[1] != "lift") {
+			text = tr(ctx, "limits.usage")
+			break
+		}
+		key := fmt.Sprintf("user:%d", userID)
+
+		if len(args) == 2 {
+			if err := a.limits.user.Lift(ctx, key); err != nil {
+				replyError(ctx, b, update, "lifting limits", err)
+				return
+			}
+			txn.AddAttribute("limits_lifted", userID)
+			text = tr(ctx, "limits.lifted", userID)
+			break
+		}
+
+		status, err := a.limits.user.Status(ctx, key)
+		if err != nil {
+			replyError(ctx, b, update, "getting limit status", err)
+			return
+		}
+		ban := tr(ctx, "limits.not_banned")
+		if status.BanTTL > 0 {
+			ban = formatWait(status.BanTTL)
+		}
+		text = tr(ctx, "limits.status", userID, status.Strikes, ban)
+	default:
+		text = tr(ctx, "limits.usage")
+	}
+
+	if err := sendMessage(ctx, b, chatID, text); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	rateCallbackPrefix      = "rate:"
+	rateStarsCallbackPrefix = "rate_stars:"
+	rateTagCallbackPrefix   = "rate_tag:"
+	rateDoneCallbackPrefix  = "rate_done:"
+)
+
+func ratingText(ctx context.Context, s bench.RatingSummary) string {
+	if s.Count == 0 {
+		return tr(ctx, "rating.none")
+	}
+	text := fmt.Sprintf("⭐ %.1f (%d)", s.Average(), s.Count)
+	for _, tag := range s.TopTags() {
+		text += " " + tag.Emoji()
+	}
+	return text
+}
+
+// rateCallback asks the user how many stars the bench deserves.
+func (a *App) rateCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.rate")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	gisID := strings.TrimPrefix(query.Data, rateCallbackPrefix)
+
+	found, err := a.store.GetBenchByID(ctx, gisID)
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.bench_gone")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		replyError(ctx, b, update, "getting bench by id", err)
+		return
+	}
+
+	row := make([]models.InlineKeyboardButton, 0, 5)
+	for stars := 1; stars <= 5; stars++ {
+		row = append(row, models.InlineKeyboardButton{
+			Text:         fmt.Sprintf("%d⭐", stars),
+			CallbackData: fmt.Sprintf("%s%s:%d", rateStarsCallbackPrefix, gisID, stars),
+		})
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	msg := tr(ctx, "rating.ask", found.Address())
+	err = sendMessageWithKeyboard(ctx, b, callbackChatID(query), msg, [][]models.InlineKeyboardButton{row})
+	if err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// rateStarsCallback stores the stars and offers the tags to describe the bench.
+func (a *App) rateStarsCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.rate_stars")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	payload := strings.TrimPrefix(query.Data, rateStarsCallbackPrefix)
+	sep := strings.LastIndex(payload, ":")
+	if sep < 0 {
+		log.Printf("malformed rating callback data: %s", query.Data)
+		return
+	}
+	gisID := payload[:sep]
+	stars, err := strconv.Atoi(payload[sep+1:])
+	if err != nil || stars < 1 || stars > 5 {
+		log.Printf("invalid rating: %s", query.Data)
+		return
+	}
+
+	rating := bench.Rating{GisID: gisID, UserID: query.From.ID, Stars: stars}
+	if previous, err := a.store.GetRating(ctx, gisID, query.From.ID); err == nil && previous != nil {
+		rating.Tags = previous.Tags
+	}
+	err = a.store.RateBench(ctx, rating)
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.bench_gone")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		noticeError(ctx, "rating bench", err)
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "rating.error")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	txn.AddAttribute("rating_stars", stars)
+
+	if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "rating.thanks")); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	text := tr(ctx, "rating.tags_prompt", strings.Repeat("⭐", stars))
+	if err := editMessageWithKeyboard(ctx, b, query, text, ratingTagsKeyboard(ctx, rating)); err != nil {
+		noticeError(ctx, "editing message", err)
+	}
+}
+
+// rateTagCallback toggles a tag on the user's rating.
+func (a *App) rateTagCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.rate_tag")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	payload := strings.TrimPrefix(query.Data, rateTagCallbackPrefix)
+	sep := strings.LastIndex(payload, ":")
+	if sep < 0 {
+		log.Printf("malformed rating tag callback data: %s", query.Data)
+		return
+	}
+	gisID, tag := payload[:sep], bench.RatingTag(payload[sep+1:])
+	if !tag.Valid() {
+		log.Printf("unknown rating tag: %s", tag)
+		return
+	}
+
+	rating, err := a.store.GetRating(ctx, gisID, query.From.ID)
+	if err != nil {
+		replyError(ctx, b, update, "getting rating", err)
+		return
+	}
+	if rating == nil {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "rating.stars_first")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+
+	rating.Tags = toggleTag(rating.Tags, tag)
+	err = a.store.RateBench(ctx, *rating)
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.bench_gone")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		noticeError(ctx, "rating bench", err)
+		return
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	text := tr(ctx, "rating.tags_prompt", strings.Repeat("⭐", rating.Stars))
+	if err := editMessageWithKeyboard(ctx, b, query, text, ratingTagsKeyboard(ctx, *rating)); err != nil {
+		noticeError(ctx, "editing message", err)
+	}
+}
+
+func (a *App) rateDoneCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.rate_done")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	gisID := strings.TrimPrefix(query.Data, rateDoneCallbackPrefix)
+
+	summaries, err := a.store.RatingSummaries(ctx, []string{gisID})
+	if err != nil {
+		noticeError(ctx, "getting rating summary", err)
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	text := tr(ctx, "rating.saved", ratingText(ctx, summaries[gisID]))
+	if err := editMessageWithKeyboard(ctx, b, query, text, nil); err != nil {
+		noticeError(ctx, "editing message", err)
+	}
+}
+
+func ratingTagsKeyboard(ctx context.Context, rating bench.Rating) [][]models.InlineKeyboardButton {
+	selected := make(map[bench.RatingTag]bool)
+	for _, tag := range rating.Tags {
+		selected[tag] = true
+	}
+
+	var row []models.InlineKeyboardButton
+	for _, tag := range bench.RatingTags {
+		text := tr(ctx, "rating.tag."+string(tag))
+		if selected[tag] {
+			text = "✅ " + text
+		}
+		row = append(row, models.InlineKeyboardButton{
+			Text:         text,
+			CallbackData: fmt.Sprintf("%s%s:%s", rateTagCallbackPrefix, rating.GisID, tag),
+		})
+	}
+
+	return [][]models.InlineKeyboardButton{
+		row[:len(row)/2],
+		row[len(row)/2:],
+		{{Text: tr(ctx, "rating.button_done"), CallbackData: rateDoneCallbackPrefix + rating.GisID}},
+	}
+}
+
+func toggleTag(tags []bench.RatingTag, tag bench.RatingTag) []bench.RatingTag {
+	for i, t := range tags {
+		if t == tag {
+			return append(tags[:i], tags[i+1:]...)
+		}
+	}
+	return append(tags, tag)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/rendercache"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+func newRenderCache(cfg *config.Config, store rendercache.Store) *rendercache.Cache {
+	ttl := time.Duration(cfg.RenderCacheTTLMinutes) * time.Minute
+	return rendercache.New(store, ttl, float64(cfg.RenderCacheCellMetres))
+}
+
+// renderedMap is a map ready to send, either as image or, once Telegram has
+// it, by file ID.
+type renderedMap struct {
+	rendercache.Entry
+	// key is where the map is cached, empty if it is not.
+	key string
+	// req draws the map again when only its file ID was cached and Telegram
+	// no longer has the file.
+	req maps.Request
+}
+
+// renderMap renders req, or takes it from the render cache. Cache errors are
+// noticed and the map rendered anyway.
+//
+// Maps are cached per cell: a search from anywhere in the cell that finds
+// the same benches is sent the map drawn for the first search there.
+func (a *App) renderMap(ctx context.Context, req maps.Request) (renderedMap, error) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("render_map")
+	defer segment.End()
+
+	m := renderedMap{req: req}
+	if a.renders != nil {
+		version, err := a.store.DatasetVersion(ctx)
+		if err != nil {
+			noticeError(ctx, "getting dataset version", err)
+		} else {
+			cell := req
+			cell.Lat, cell.Lon = a.renders.Snap(req.Lat, req.Lon)
+			m.key = rendercache.Key(version, req.Theme.Name, cell.Lat, cell.Lon, req.Radius, a.renderer.Digest(cell))
+		}
+	}
+	if m.key != "" {
+		e, err := a.renders.Get(ctx, m.key)
+		if err == nil {
+			txn.AddAttribute("render_cache", "hit")
+			m.Entry = e
+			return m, nil
+		}
+		if !errors.Is(err, rendercache.ErrMiss) {
+			noticeError(ctx, "reading render cache", err)
+		}
+		txn.AddAttribute("render_cache", "miss")
+	}
+
+	img, err := a.renderer.RenderBytes(ctx, req)
+	if err != nil {
+		return renderedMap{}, err
+	}
+	m.Image = img
+	if m.key != "" {
+		if err := a.renders.Put(ctx, m.key, m.Entry); err != nil {
+			noticeError(ctx, "writing render cache", err)
+			m.key = ""
+		}
+	}
+	return m, nil
+}
+
+// sendMap sends m, by file ID if it was sent before, and records the file ID
+// of new uploads in the render cache.
+func (a *App) sendMap(ctx context.Context, b *bot.Bot, chatID int64, m renderedMap) error {
+	if m.FileID != "" {
+		_, err := sendPhoto(ctx, b, chatID, &models.InputFileString{Data: m.FileID})
+		if err == nil {
+			return nil
+		}
+		// The file may be gone from Telegram, upload it again.
+		noticeError(ctx, "sending cached map", err)
+		if m.Image == nil {
+			if m.Image, err = a.renderer.RenderBytes(ctx, m.req); err != nil {
+				return err
+			}
+		}
+	}
+
+	fileID, err := sendPhoto(ctx, b, chatID, &models.InputFileUpload{
+		Filename: "map" + a.renderer.Encoding().Format.Extension(),
+		Data:     bytes.NewReader(m.Image),
+	})
+	if err != nil {
+		return err
+	}
+	if m.key != "" && fileID != "" {
+		if err := a.renders.SetFileID(ctx, m.key, fileID); err != nil {
+			noticeError(ctx, "writing render cache", err)
+		}
+	}
+	return nil
+}
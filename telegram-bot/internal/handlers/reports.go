@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	reportCallbackPrefix         = "report:"
+	reportCategoryCallbackPrefix = "report_cat:"
+	reportModerateCallbackPrefix = "report_mod:"
+
+	// recentReportWindow is how long an unresolved report keeps flagging a bench.
+	recentReportWindow = 14 * 24 * time.Hour
+	reportPhotoTimeout = 10 * time.Minute
+	reportsQueueLimit  = 10
+)
+
+// deRankReported moves benches with recent unresolved reports to the end of
+// the list, keeping the distance order within each group.
+func deRankReported(benches []bench.Bench, reports map[string][]bench.Report) []bench.Bench {
+	sort.SliceStable(benches, func(i, j int) bool {
+		return len(reports[benches[i].GisID]) == 0 && len(reports[benches[j].GisID]) > 0
+	})
+	return benches
+}
+
+func reportSummary(ctx context.Context, reports []bench.Report) string {
+	seen := make(map[bench.ReportCategory]bool)
+	var categories []string
+	for _, r := range reports {
+		if seen[r.Category] {
+			continue
+		}
+		seen[r.Category] = true
+		categories = append(categories, tr(ctx, "report.category."+string(r.Category)))
+	}
+	return strings.Join(categories, ", ")
+}
+
+// reportBenchCallback asks the user which kind of problem the bench has.
+func (a *App) reportBenchCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.report_bench")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	gisID := strings.TrimPrefix(query.Data, reportCallbackPrefix)
+
+	found, err := a.store.GetBenchByID(ctx, gisID)
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.bench_gone")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		replyError(ctx, b, update, "getting bench by id", err)
+		return
+	}
+
+	keyboard := make([][]models.InlineKeyboardButton, 0, len(bench.ReportCategories))
+	for _, category := range bench.ReportCategories {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{
+			Text:         tr(ctx, "report.category."+string(category)),
+			CallbackData: fmt.Sprintf("%s%s:%s", reportCategoryCallbackPrefix, gisID, category),
+		}})
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	msg := tr(ctx, "report.ask", found.Address())
+	err = sendMessageWithKeyboard(ctx, b, callbackChatID(query), msg, keyboard)
+	if err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// reportCategoryCallback stores the report and offers to attach a photo.
+func (a *App) reportCategoryCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.report_category")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	payload := strings.TrimPrefix(query.Data, reportCategoryCallbackPrefix)
+	sep := strings.LastIndex(payload, ":")
+	if sep < 0 {
+		log.Printf("malformed report callback data: %s", query.Data)
+		return
+	}
+	gisID, category := payload[:sep], bench.ReportCategory(payload[sep+1:])
+	if !category.Valid() {
+		log.Printf("unknown report category: %s", category)
+		return
+	}
+
+	report := &bench.Report{
+		GisID:        gisID,
+		Category:     category,
+		ReporterID:   query.From.ID,
+		ReporterName: reporterName(query.From),
+	}
+
+	err := a.store.CreateReport(ctx, report)
+	if errors.Is(err, storage.ErrConflict) {
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "report.duplicate")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			noticeError(ctx, "creating report", err)
+		}
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "report.error")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	txn.AddAttribute("report_category", string(category))
+
+	if err := a.store.SetPendingReportPhoto(ctx, query.From.ID, report.ID, reportPhotoTimeout); err != nil {
+		noticeError(ctx, "setting pending report photo", err)
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "report.thanks")); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	minutes := int(reportPhotoTimeout.Minutes())
+	msg := trn(ctx, "report.saved", minutes, report.ID, tr(ctx, "report.category."+string(category)), minutes)
+	if err := sendMessage(ctx, b, callbackChatID(query), msg); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// reportPhotoHandler attaches a photo to the user's most recent report.
+func (a *App) reportPhotoHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.report_photo")
+	defer segment.End()
+
+	if update.Message.From == nil {
+		return
+	}
+
+	reportID, err := a.store.TakePendingReportPhoto(ctx, update.Message.From.ID)
+	if err != nil {
+		replyError(ctx, b, update, "getting pending report photo", err)
+		return
+	}
+	if reportID == 0 {
+		return
+	}
+
+	// Telegram sends several sizes of the same photo, the last one is the largest.
+	photo := update.Message.Photo[len(update.Message.Photo)-1]
+	if err := a.store.AttachReportPhoto(ctx, reportID, photo.FileID); err != nil {
+		noticeError(ctx, "attaching report photo", err)
+		return
+	}
+
+	msg := tr(ctx, "report.photo_attached", reportID)
+	if err := sendMessage(ctx, b, update.Message.Chat.ID, msg); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// reportsQueueHandler sends the moderation queue to an admin.
+func (a *App) reportsQueueHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.reports")
+	defer segment.End()
+
+	reports, err := a.store.ListOpenReports(ctx, reportsQueueLimit)
+	if err != nil {
+		replyError(ctx, b, update, "listing open reports", err)
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	if len(reports) == 0 {
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "report.queue_empty")); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return
+	}
+
+	for _, r := range reports {
+		text := tr(ctx, "report.queue_item",
+			r.ID, tr(ctx, "report.category."+string(r.Category)), r.GisID, r.ReporterName, r.CreatedAt.Format("2006-01-02 15:04"))
+		if found, err := a.store.GetBenchByID(ctx, r.GisID); err == nil {
+			text = fmt.Sprintf("%s\n%s", text, tr(ctx, "report.queue_address", found.Address()))
+		}
+
+		keyboard := [][]models.InlineKeyboardButton{{
+			{Text: tr(ctx, "report.button_resolve"), CallbackData: fmt.Sprintf("%s%d:%s", reportModerateCallbackPrefix, r.ID, bench.ReportResolved)},
+			{Text: tr(ctx, "report.button_dismiss"), CallbackData: fmt.Sprintf("%s%d:%s", reportModerateCallbackPrefix, r.ID, bench.ReportDismissed)},
+		}}
+
+		if r.PhotoFileID != "" {
+			err = sendPhotoByID(ctx, b, chatID, r.PhotoFileID, text, keyboard)
+		} else {
+			err = sendMessageWithKeyboard(ctx, b, chatID, text, keyboard)
+		}
+		if err != nil {
+			txn.NoticeError(err)
+			log.Printf("error sending report #%d: %v", r.ID, err)
+		}
+	}
+}
+
+func (a *App) reportModerateCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.report_moderate")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	if !isAdmin(ctx, a.cfg.AdminUserID, query.From.ID) {
+		log.Printf("unauthorized moderation attempt by %d", query.From.ID)
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.unauthorized")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+
+	payload := strings.TrimPrefix(query.Data, reportModerateCallbackPrefix)
+	idStr, status, ok := strings.Cut(payload, ":")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if !ok || err != nil || !bench.ReportStatus(status).Valid() {
+		log.Printf("malformed moderation callback data: %s", query.Data)
+		return
+	}
+
+	if err := a.store.SetReportStatus(ctx, id, bench.ReportStatus(status)); err != nil {
+		noticeError(ctx, "updating report status", err)
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "report.update_error")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "report.status."+status, id)); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+}
+
+func reporterName(user models.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/i18n"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/router"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+)
+
+// Route registers the bot's commands, messages and callbacks. Commands
+// addressed to other bots than botUsername are ignored.
+func (a *App) Route(botUsername string) {
+	admin := a.adminOnly()
+	render := router.RateLimit(a.limits.render, a.userLimitKey, a.renderLimited)
+
+	r := router.New(botUsername)
+	r.Use(
+		router.Recover(panicApology),
+		router.Tracing(),
+		router.Logger(),
+		router.RateLimit(a.limits.user, a.userLimitKey, a.userLimited),
+		router.RateLimit(a.limits.global, a.globalLimitKey, a.globalLimited),
+		a.userContext(),
+	)
+
+	r.Command("start", startHandler)
+	r.Command("add_bench", a.addBenchHandler)
+	r.Command("language", languageHandler)
+	r.Command("settings", settingsHandler)
+	r.Command("cancel", a.cancelHandler)
+	r.Command("heatmap", a.heatmapHandler, render)
+	r.Command("export", a.exportHandler)
+	r.Command("update_benches", a.updateBenchesHandler, admin)
+	r.Command("reports", a.reportsQueueHandler, admin)
+	r.Command("submissions", a.submissionsQueueHandler, admin)
+	r.Command("limits", a.limitsHandler, admin)
+
+	r.Message("location", hasLocation, func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if !a.submissionLocationHandler(ctx, b, update) {
+			a.locationHandler(ctx, b, update)
+		}
+	}, render)
+	r.Message("photo", hasPhoto, func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if !a.submissionPhotoHandler(ctx, b, update) {
+			a.reportPhotoHandler(ctx, b, update)
+		}
+	})
+
+	r.Callback(languageCallbackPrefix, a.languageCallback)
+	r.Callback(settingsCallbackPrefix, a.settingsCallback)
+	r.Callback(benchCallbackPrefix, a.benchCallback)
+	r.Callback(bestCallbackPrefix, func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		a.rerankCallback(ctx, b, update, bestCallbackPrefix, rankByScore)
+	}, render)
+	r.Callback(shadeCallbackPrefix, func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		a.rerankCallback(ctx, b, update, shadeCallbackPrefix, rankByShade)
+	}, render)
+	r.Callback(exportCallbackPrefix, a.exportCallback)
+	r.Callback(exportFormatCallbackPrefix, a.exportFormatCallback)
+	r.Callback(rateStarsCallbackPrefix, a.rateStarsCallback)
+	r.Callback(rateTagCallbackPrefix, a.rateTagCallback)
+	r.Callback(rateDoneCallbackPrefix, a.rateDoneCallback)
+	r.Callback(rateCallbackPrefix, a.rateCallback)
+	r.Callback(reportCategoryCallbackPrefix, a.reportCategoryCallback)
+	r.Callback(reportModerateCallbackPrefix, a.reportModerateCallback)
+	r.Callback(submissionReviewCallbackPrefix, a.submissionReviewCallback)
+	r.Callback(reportCallbackPrefix, a.reportBenchCallback)
+	r.Callback("", unknownCallback)
+
+	a.router = r
+}
+
+// panicApology apologises for a handler that panicked. The user's settings
+// may be what failed to load, so only their client language is used.
+func panicApology(ctx context.Context, b *bot.Bot, update *models.Update) {
+	apologize(clientLocale(ctx, update), b, update, errClassPanic)
+}
+
+// clientLocale sets the locale to the language of the sender's client.
+func clientLocale(ctx context.Context, update *models.Update) context.Context {
+	locale := i18n.Default
+	if from := router.Sender(update); from != nil {
+		locale = i18n.Detect(from.LanguageCode)
+	}
+	return i18n.WithLocale(ctx, locale)
+}
+
+func hasLocation(m *models.Message) bool {
+	return m.Location != nil
+}
+
+func hasPhoto(m *models.Message) bool {
+	return len(m.Photo) > 0
+}
+
+// userContext loads the sender's settings and locale into the context.
+func (a *App) userContext() router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			from := router.Sender(update)
+			prefs := a.loadUserSettings(ctx, from)
+			ctx = settings.WithSettings(ctx, prefs)
+			ctx = i18n.WithLocale(ctx, userLocale(prefs, from))
+			next(ctx, b, update)
+		}
+	}
+}
+
+// adminOnly restricts a command to the admin user.
+func (a *App) adminOnly() router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			// Messages sent on behalf of a chat have no sender and are never
+			// the admin's.
+			from := router.Sender(update)
+			if from == nil || !isAdmin(ctx, a.cfg.AdminUserID, from.ID) {
+				log.Printf("unauthorized admin command received: %s", update.Message.Text)
+				err := sendMessage(ctx, b, update.Message.Chat.ID, tr(ctx, "common.unauthorized"))
+				if err != nil {
+					log.Printf("error sending message: %v", err)
+				}
+				return
+			}
+			log.Printf("authorized admin command received: %s", update.Message.Text)
+			next(ctx, b, update)
+		}
+	}
+}
+
+func unknownCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Printf("unknown callback data: %s", update.CallbackQuery.Data)
+	if err := answerCallbackQuery(ctx, b, update.CallbackQuery.ID, ""); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+}
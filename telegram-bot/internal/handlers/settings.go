@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/i18n"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+const settingsCallbackPrefix = "set:"
+
+// Settings fields, as used in callback data.
+const (
+	settingLanguage    = "language"
+	settingRadius      = "radius"
+	settingLayers      = "layers"
+	settingMapStyle    = "map_style"
+	settingTheme       = "theme"
+	settingUnits       = "units"
+	settingResultCount = "result_count"
+)
+
+var settingFields = []string{
+	settingLanguage,
+	settingRadius,
+	settingLayers,
+	settingMapStyle,
+	settingTheme,
+	settingUnits,
+	settingResultCount,
+}
+
+// loadUserSettings returns the sender's settings, or the defaults when they
+// cannot be loaded.
+func (a *App) loadUserSettings(ctx context.Context, from *models.User) settings.Settings {
+	if from == nil {
+		return settings.Default()
+	}
+
+	prefs, err := a.store.GetUserSettings(ctx, from.ID)
+	if err != nil {
+		noticeError(ctx, "getting user settings", err)
+		return settings.Default()
+	}
+	return prefs
+}
+
+func settingsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.settings")
+	defer segment.End()
+
+	err := sendMessageWithKeyboard(ctx, b, update.Message.Chat.ID, settingsText(ctx), settingsKeyboard(ctx))
+	if err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// settingsCallback handles the /settings menu. The callback data is either
+// "set:menu", "set:<field>" to open the options for a field, or
+// "set:<field>:<value>" to pick one of them.
+func (a *App) settingsCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.settings_callback")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	field, value, hasValue := strings.Cut(strings.TrimPrefix(query.Data, settingsCallbackPrefix), ":")
+
+	if !hasValue {
+		if err := answerCallbackQuery(ctx, b, query.ID, ""); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		text, keyboard := settingsText(ctx), settingsKeyboard(ctx)
+		if field != "menu" {
+			text, keyboard = tr(ctx, "settings.prompt."+field), a.settingOptionsKeyboard(ctx, field)
+		}
+		if err := editMessageWithKeyboard(ctx, b, query, text, keyboard); err != nil {
+			noticeError(ctx, "editing message", err)
+		}
+		return
+	}
+
+	prefs := settings.FromContext(ctx)
+	prefs.Layers = copyLayers(prefs.Layers)
+	if !a.applySetting(&prefs, field, value) {
+		log.Printf("invalid settings callback data: %s", query.Data)
+		return
+	}
+
+	if err := a.store.SaveUserSettings(ctx, query.From.ID, prefs); err != nil {
+		noticeError(ctx, "saving user settings", err)
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "settings.error")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+	txn.AddAttribute("setting", field)
+
+	ctx = settings.WithSettings(ctx, prefs)
+	if field == settingLanguage {
+		ctx = i18n.WithLocale(ctx, userLocale(prefs, &query.From))
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "settings.saved")); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	// Layers are toggled one at a time, so stay on their options.
+	text, keyboard := settingsText(ctx), settingsKeyboard(ctx)
+	if field == settingLayers {
+		text, keyboard = tr(ctx, "settings.prompt.layers"), a.settingOptionsKeyboard(ctx, settingLayers)
+	}
+	if err := editMessageWithKeyboard(ctx, b, query, text, keyboard); err != nil {
+		noticeError(ctx, "editing message", err)
+	}
+}
+
+// applySetting sets field to value, reporting whether the value is valid.
+func (a *App) applySetting(prefs *settings.Settings, field, value string) bool {
+	switch field {
+	case settingLanguage:
+		l, ok := i18n.Parse(value)
+		if !ok {
+			return false
+		}
+		prefs.Language = string(l)
+	case settingRadius:
+		radius, err := strconv.ParseFloat(value, 64)
+		if err != nil || !settings.ValidRadius(radius) {
+			return false
+		}
+		prefs.Radius = radius
+	case settingLayers:
+		layer := settings.Layer(value)
+		if !layer.Valid() {
+			return false
+		}
+		prefs.Layers[layer] = !prefs.Layers[layer]
+	case settingMapStyle:
+		style := maps.Style(value)
+		if !style.Valid() {
+			return false
+		}
+		prefs.MapStyle = style
+	case settingTheme:
+		if !a.themes.Has(value) {
+			return false
+		}
+		prefs.Theme = value
+	case settingUnits:
+		units := settings.Units(value)
+		if !units.Valid() {
+			return false
+		}
+		prefs.Units = units
+	case settingResultCount:
+		count, err := strconv.Atoi(value)
+		if err != nil || !settings.ValidResultCount(count) {
+			return false
+		}
+		prefs.ResultCount = count
+	default:
+		return false
+	}
+	return true
+}
+
+// themeLabel returns the translated name of a built-in theme, or the name of
+// a theme from the themes file.
+func themeLabel(ctx context.Context, name string) string {
+	if label, ok := i18n.Lookup(i18n.FromContext(ctx), "settings.theme."+name); ok {
+		return label
+	}
+	return name
+}
+
+func copyLayers(layers map[settings.Layer]bool) map[settings.Layer]bool {
+	c := make(map[settings.Layer]bool, len(layers))
+	for l, on := range layers {
+		c[l] = on
+	}
+	return c
+}
+
+func settingsText(ctx context.Context) string {
+	prefs := settings.FromContext(ctx)
+
+	var layers []string
+	for _, l := range settings.Layers {
+		if prefs.HasLayer(l) {
+			layers = append(layers, tr(ctx, "settings.layer."+string(l)))
+		}
+	}
+	if len(layers) == 0 {
+		layers = append(layers, tr(ctx, "settings.layers_none"))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(ctx, "settings.title"))
+	fmt.Fprintf(&sb, "\n\n%s: %s", tr(ctx, "settings.language"), i18n.FromContext(ctx).Name())
+	fmt.Fprintf(&sb, "\n%s: %s", tr(ctx, "settings.radius"), prefs.FormatDistance(prefs.Radius))
+	fmt.Fprintf(&sb, "\n%s: %s", tr(ctx, "settings.layers"), strings.Join(layers, ", "))
+	fmt.Fprintf(&sb, "\n%s: %s", tr(ctx, "settings.map_style"), tr(ctx, "settings.map_style."+string(prefs.MapStyle)))
+	fmt.Fprintf(&sb, "\n%s: %s", tr(ctx, "settings.theme"), themeLabel(ctx, prefs.Theme))
+	fmt.Fprintf(&sb, "\n%s: %s", tr(ctx, "settings.units"), tr(ctx, "settings.units."+string(prefs.Units)))
+	fmt.Fprintf(&sb, "\n%s: %d", tr(ctx, "settings.result_count"), prefs.ResultCount)
+	return sb.String()
+}
+
+func settingsKeyboard(ctx context.Context) [][]models.InlineKeyboardButton {
+	var keyboard [][]models.InlineKeyboardButton
+	for i := 0; i < len(settingFields); i += 2 {
+		var row []models.InlineKeyboardButton
+		for _, field := range settingFields[i:min(i+2, len(settingFields))] {
+			row = append(row, models.InlineKeyboardButton{
+				Text:         tr(ctx, "settings."+field),
+				CallbackData: settingsCallbackPrefix + field,
+			})
+		}
+		keyboard = append(keyboard, row)
+	}
+	return keyboard
+}
+
+// settingOptionsKeyboard lists the values of field, marking the current one.
+func (a *App) settingOptionsKeyboard(ctx context.Context, field string) [][]models.InlineKeyboardButton {
+	prefs := settings.FromContext(ctx)
+
+	type option struct {
+		text, value string
+		selected    bool
+	}
+	var options []option
+	switch field {
+	case settingLanguage:
+		for _, l := range i18n.Supported {
+			options = append(options, option{l.Name(), string(l), l == i18n.FromContext(ctx)})
+		}
+	case settingRadius:
+		for _, r := range settings.RadiusOptions {
+			options = append(options, option{prefs.FormatDistance(r), strconv.FormatFloat(r, 'f', -1, 64), r == prefs.Radius})
+		}
+	case settingLayers:
+		for _, l := range settings.Layers {
+			options = append(options, option{tr(ctx, "settings.layer."+string(l)), string(l), prefs.HasLayer(l)})
+		}
+	case settingMapStyle:
+		for _, s := range maps.Styles {
+			options = append(options, option{tr(ctx, "settings.map_style."+string(s)), string(s), s == prefs.MapStyle})
+		}
+	case settingTheme:
+		for _, name := range a.themes.Names() {
+			options = append(options, option{themeLabel(ctx, name), name, name == prefs.Theme})
+		}
+	case settingUnits:
+		for _, u := range settings.UnitOptions {
+			options = append(options, option{tr(ctx, "settings.units."+string(u)), string(u), u == prefs.Units})
+		}
+	case settingResultCount:
+		for _, n := range settings.ResultCountOptions {
+			options = append(options, option{strconv.Itoa(n), strconv.Itoa(n), n == prefs.ResultCount})
+		}
+	}
+
+	var keyboard [][]models.InlineKeyboardButton
+	for _, o := range options {
+		text := o.text
+		if o.selected {
+			text = "✅ " + text
+		}
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{
+			Text:         text,
+			CallbackData: settingsCallbackPrefix + field + ":" + o.value,
+		}})
+	}
+	return append(keyboard, []models.InlineKeyboardButton{{
+		Text:         tr(ctx, "settings.button_back"),
+		CallbackData: settingsCallbackPrefix + "menu",
+	}})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/shade"
+)
+
+// loadShadeIndex loads the obstacle dataset on first use. It returns nil when
+// no dataset is configured or it cannot be read, disabling shade estimates.
+func (a *App) loadShadeIndex(ctx context.Context) *shade.Index {
+	if a.cfg.ShadeDatasetPath == "" {
+		return nil
+	}
+
+	a.shadeOnce.Do(func() {
+		idx, err := shade.LoadIndex(ctx, a.cfg.ShadeDatasetPath)
+		if err != nil {
+			newrelic.FromContext(ctx).NoticeError(err)
+			log.Printf("error loading shade dataset %s, shade estimates disabled: %v", a.cfg.ShadeDatasetPath, err)
+			return
+		}
+		log.Printf("loaded %d shade obstacles from %s", idx.Len(), a.cfg.ShadeDatasetPath)
+		a.shadeIndex = idx
+	})
+	return a.shadeIndex
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/i18n"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	submissionReviewCallbackPrefix = "submission_review:"
+
+	submissionDraftTimeout = 15 * time.Minute
+	submissionsQueueLimit  = 10
+)
+
+// addBenchHandler starts the flow to propose a bench that is missing from the map.
+func (a *App) addBenchHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.add_bench")
+	defer segment.End()
+
+	if update.Message.From == nil {
+		return
+	}
+	if err := a.store.StartSubmissionDraft(ctx, update.Message.From.ID, submissionDraftTimeout); err != nil {
+		noticeError(ctx, "starting submission draft", err)
+		return
+	}
+
+	if err := sendMessage(ctx, b, update.Message.Chat.ID, tr(ctx, "submission.start")); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+func (a *App) cancelHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.cancel")
+	defer segment.End()
+
+	if update.Message.From == nil {
+		return
+	}
+	if err := a.store.DeleteSubmissionDraft(ctx, update.Message.From.ID); err != nil {
+		noticeError(ctx, "deleting submission draft", err)
+	}
+
+	if err := sendMessage(ctx, b, update.Message.Chat.ID, tr(ctx, "submission.cancelled")); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+}
+
+// submissionLocationHandler stores the location of a bench being submitted.
+// It returns false when the user has no submission in progress, so the
+// location is treated as a regular search.
+func (a *App) submissionLocationHandler(ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.submission_location")
+	defer segment.End()
+
+	if update.Message.From == nil {
+		return false
+	}
+
+	draft, err := a.store.GetSubmissionDraft(ctx, update.Message.From.ID)
+	if err != nil {
+		// Carry on as if there was no draft.
+		noticeError(ctx, "getting submission draft", err)
+		return false
+	}
+	if draft == nil {
+		return false
+	}
+
+	loc := update.Message.Location
+	err = a.store.SetSubmissionDraftLocation(ctx, update.Message.From.ID, loc.Latitude, loc.Longitude, submissionDraftTimeout)
+	if err != nil {
+		noticeError(ctx, "saving submission location", err)
+		return true
+	}
+
+	if err := sendMessage(ctx, b, update.Message.Chat.ID, tr(ctx, "submission.location_saved")); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+	return true
+}
+
+// submissionPhotoHandler completes a submission once its photo arrives and
+// sends it to the admin for review. It returns false when the photo is not
+// part of a submission.
+func (a *App) submissionPhotoHandler(ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.submission_photo")
+	defer segment.End()
+
+	if update.Message.From == nil {
+		return false
+	}
+
+	draft, err := a.store.GetSubmissionDraft(ctx, update.Message.From.ID)
+	if err != nil {
+		// Carry on as if there was no draft.
+		noticeError(ctx, "getting submission draft", err)
+		return false
+	}
+	if draft == nil {
+		return false
+	}
+
+	chatID := update.Message.Chat.ID
+	if !draft.HasLocation {
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "submission.location_first")); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return true
+	}
+
+	photo := update.Message.Photo[len(update.Message.Photo)-1]
+	submission := &bench.Submission{
+		Latitude:      draft.Latitude,
+		Longitude:     draft.Longitude,
+		PhotoFileID:   photo.FileID,
+		SubmitterID:   update.Message.From.ID,
+		SubmitterName: reporterName(*update.Message.From),
+		Language:      string(i18n.FromContext(ctx)),
+	}
+	if err := a.store.CreateSubmission(ctx, submission); err != nil {
+		noticeError(ctx, "creating submission", err)
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "submission.error")); err != nil {
+			log.Printf("error sending message: %v", err)
+		}
+		return true
+	}
+
+	if err := a.store.DeleteSubmissionDraft(ctx, update.Message.From.ID); err != nil {
+		noticeError(ctx, "deleting submission draft", err)
+	}
+
+	msg := tr(ctx, "submission.thanks", submission.ID)
+	if err := sendMessage(ctx, b, chatID, msg); err != nil {
+		noticeError(ctx, "sending message", err)
+	}
+
+	if a.cfg.AdminUserID != 0 {
+		adminCtx := i18n.WithLocale(ctx, a.adminLocale(ctx))
+		if err := sendSubmissionForReview(adminCtx, b, a.cfg.AdminUserID, *submission); err != nil {
+			txn.NoticeError(err)
+			log.Printf("error notifying admin about submission #%d: %v", submission.ID, err)
+		}
+	}
+	return true
+}
+
+// submissionsQueueHandler sends the pending submissions to an admin.
+func (a *App) submissionsQueueHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.submissions")
+	defer segment.End()
+
+	submissions, err := a.store.ListPendingSubmissions(ctx, submissionsQueueLimit)
+	if err != nil {
+		replyError(ctx, b, update, "listing pending submissions", err)
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	if len(submissions) == 0 {
+		if err := sendMessage(ctx, b, chatID, tr(ctx, "submission.queue_empty")); err != nil {
+			noticeError(ctx, "sending message", err)
+		}
+		return
+	}
+
+	for _, s := range submissions {
+		if err := sendSubmissionForReview(ctx, b, chatID, s); err != nil {
+			txn.NoticeError(err)
+			log.Printf("error sending submission #%d: %v", s.ID, err)
+		}
+	}
+}
+
+func sendSubmissionForReview(ctx context.Context, b *bot.Bot, chatID int64, s bench.Submission) error {
+	caption := tr(ctx, "submission.review_caption",
+		s.ID, s.Latitude, s.Longitude, s.SubmitterName, s.CreatedAt.Format("2006-01-02 15:04"))
+	keyboard := [][]models.InlineKeyboardButton{{
+		{Text: tr(ctx, "submission.button_approve"), CallbackData: fmt.Sprintf("%s%d:%s", submissionReviewCallbackPrefix, s.ID, bench.SubmissionApproved)},
+		{Text: tr(ctx, "submission.button_reject"), CallbackData: fmt.Sprintf("%s%d:%s", submissionReviewCallbackPrefix, s.ID, bench.SubmissionRejected)},
+	}}
+	return sendPhotoByID(ctx, b, chatID, s.PhotoFileID, caption, keyboard)
+}
+
+func (a *App) submissionReviewCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("command.submission_review")
+	defer segment.End()
+
+	query := update.CallbackQuery
+	if !isAdmin(ctx, a.cfg.AdminUserID, query.From.ID) {
+		log.Printf("unauthorized submission review attempt by %d", query.From.ID)
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "common.unauthorized")); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+
+	payload := strings.TrimPrefix(query.Data, submissionReviewCallbackPrefix)
+	idStr, status, ok := strings.Cut(payload, ":")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if !ok || err != nil || !bench.SubmissionStatus(status).Valid() || bench.SubmissionStatus(status) == bench.SubmissionPending {
+		log.Printf("malformed submission review callback data: %s", query.Data)
+		return
+	}
+
+	submission, err := a.store.ReviewSubmission(ctx, id, bench.SubmissionStatus(status))
+	if err != nil {
+		noticeError(ctx, "reviewing submission", err)
+		if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "submission.review_error", id)); err != nil {
+			log.Printf("error answering callback query: %v", err)
+		}
+		return
+	}
+
+	if err := answerCallbackQuery(ctx, b, query.ID, tr(ctx, "submission.status."+status, id)); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+
+	// Reply to the submitter in their own language, not the admin's.
+	submitterCtx := i18n.WithLocale(ctx, i18n.Detect(submission.Language))
+	msg := tr(submitterCtx, "submission.notify_rejected", id)
+	if submission.Status == bench.SubmissionApproved {
+		msg = tr(submitterCtx, "submission.notify_approved", id)
+	}
+	if err := sendMessage(submitterCtx, b, submission.SubmitterID, msg); err != nil {
+		noticeError(ctx, "notifying submitter", err)
+	}
+}
+
+func hasCommunityBench(benches []bench.Bench) bool {
+	for _, b := range benches {
+		if b.Community {
+			return true
+		}
+	}
+	return false
+}
@@ -3,14 +3,24 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"log"
-	"os"
+	"fmt"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/i18n"
 )
 
+// tr translates key into the locale of the user being served.
+func tr(ctx context.Context, key string, args ...any) string {
+	return i18n.T(i18n.FromContext(ctx), key, args...)
+}
+
+// trn is tr for messages with plural forms, chosen by n.
+func trn(ctx context.Context, key string, n int, args ...any) string {
+	return i18n.N(i18n.FromContext(ctx), key, n, args...)
+}
+
 func sendMessage(ctx context.Context, b *bot.Bot, chatID int64, text string) error {
 	txn := newrelic.FromContext(ctx)
 	txn.AddAttribute("chat_id", chatID)
@@ -26,36 +36,55 @@ func sendMessage(ctx context.Context, b *bot.Bot, chatID int64, text string) err
 	return err
 }
 
-func sendImage(ctx context.Context, b *bot.Bot, chatID int64, image []byte) error {
+func sendImage(ctx context.Context, b *bot.Bot, chatID int64, filename string, image []byte) error {
+	_, err := sendPhoto(ctx, b, chatID, &models.InputFileUpload{
+		Filename: filename,
+		Data:     bytes.NewReader(image),
+	})
+	return err
+}
+
+// sendPhoto sends photo and returns the file ID Telegram stored it as, for
+// sending it again without uploading.
+func sendPhoto(ctx context.Context, b *bot.Bot, chatID int64, photo models.InputFile) (string, error) {
 	txn := newrelic.FromContext(ctx)
 	txn.AddAttribute("chat_id", chatID)
 	segment := txn.StartSegment("telegram_api_call.send_photo")
 	defer segment.End()
 
-	_, err := b.SendPhoto(ctx, &bot.SendPhotoParams{
+	msg, err := b.SendPhoto(ctx, &bot.SendPhotoParams{
 		ChatID: chatID,
-		Photo: &models.InputFileUpload{
-			Data: bytes.NewReader(image),
-		},
+		Photo:  photo,
 	})
 	if err != nil {
 		txn.NoticeError(err)
+		return "", err
 	}
-	return err
+	if len(msg.Photo) == 0 {
+		return "", nil
+	}
+	// Sizes are listed smallest first; the largest is the original.
+	return msg.Photo[len(msg.Photo)-1].FileID, nil
 }
 
-func removeImage(ctx context.Context, imgPath string) error {
+func sendDocument(ctx context.Context, b *bot.Bot, chatID int64, filename string, data []byte, caption string) error {
 	txn := newrelic.FromContext(ctx)
-	segment := txn.StartSegment("remove_image")
+	txn.AddAttribute("chat_id", chatID)
+	segment := txn.StartSegment("telegram_api_call.send_document")
 	defer segment.End()
 
-	err := os.Remove(imgPath)
+	_, err := b.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID: chatID,
+		Document: &models.InputFileUpload{
+			Filename: filename,
+			Data:     bytes.NewReader(data),
+		},
+		Caption: caption,
+	})
 	if err != nil {
 		txn.NoticeError(err)
-		log.Printf("error removing image: %v", err)
-		return err
 	}
-	return nil
+	return err
 }
 
 func isAdmin(ctx context.Context, adminUserID, userID int64) bool {
@@ -68,3 +97,96 @@ func isAdmin(ctx context.Context, adminUserID, userID int64) bool {
 	}
 	return adminUserID == userID
 }
+
+func sendMessageWithKeyboard(ctx context.Context, b *bot.Bot, chatID int64, text string, keyboard [][]models.InlineKeyboardButton) error {
+	txn := newrelic.FromContext(ctx)
+	txn.AddAttribute("chat_id", chatID)
+	segment := txn.StartSegment("telegram_api_call.send_message")
+	defer segment.End()
+
+	params := &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+	_, err := b.SendMessage(ctx, params)
+	if err != nil {
+		txn.NoticeError(err)
+	}
+	return err
+}
+
+func answerCallbackQuery(ctx context.Context, b *bot.Bot, callbackQueryID string, text string) error {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("telegram_api_call.answer_callback_query")
+	defer segment.End()
+	_, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: callbackQueryID,
+		Text:            text,
+	})
+	if err != nil {
+		txn.NoticeError(err)
+	}
+	return err
+}
+
+// callbackChatID returns the chat the callback button was pressed in, falling
+// back to the private chat with the user when the message is inaccessible.
+func callbackChatID(query *models.CallbackQuery) int64 {
+	if query.Message.Message != nil {
+		return query.Message.Message.Chat.ID
+	}
+	if query.Message.InaccessibleMessage != nil {
+		return query.Message.InaccessibleMessage.Chat.ID
+	}
+	return query.From.ID
+}
+
+func sendPhotoByID(ctx context.Context, b *bot.Bot, chatID int64, fileID string, caption string, keyboard [][]models.InlineKeyboardButton) error {
+	txn := newrelic.FromContext(ctx)
+	txn.AddAttribute("chat_id", chatID)
+	segment := txn.StartSegment("telegram_api_call.send_photo")
+	defer segment.End()
+
+	params := &bot.SendPhotoParams{
+		ChatID:  chatID,
+		Photo:   &models.InputFileString{Data: fileID},
+		Caption: caption,
+	}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+	_, err := b.SendPhoto(ctx, params)
+	if err != nil {
+		txn.NoticeError(err)
+	}
+	return err
+}
+
+// editMessageWithKeyboard replaces the text and buttons of the message the
+// callback button belongs to.
+func editMessageWithKeyboard(ctx context.Context, b *bot.Bot, query *models.CallbackQuery, text string, keyboard [][]models.InlineKeyboardButton) error {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("telegram_api_call.edit_message_text")
+	defer segment.End()
+
+	if query.Message.Message == nil {
+		return fmt.Errorf("callback message is not accessible")
+	}
+
+	params := &bot.EditMessageTextParams{
+		ChatID:    query.Message.Message.Chat.ID,
+		MessageID: query.Message.Message.ID,
+		Text:      text,
+	}
+	if len(keyboard) > 0 {
+		params.ReplyMarkup = &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+	}
+	_, err := b.EditMessageText(ctx, params)
+	if err != nil {
+		txn.NoticeError(err)
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+type Locale string
+
+const (
+	Catalan Locale = "ca"
+	Spanish Locale = "es"
+	English Locale = "en"
+
+	Default = English
+)
+
+// Supported lists the available locales, in the order offered to users.
+var Supported = []Locale{Catalan, Spanish, English}
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// message holds the plural forms of a translation. Messages without plural
+// forms only set Other.
+type message struct {
+	One   string `json:"one"`
+	Other string `json:"other"`
+}
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		m.Other = s
+		return nil
+	}
+	type forms message
+	return json.Unmarshal(data, (*forms)(m))
+}
+
+var catalog = mustLoad()
+
+func mustLoad() map[Locale]map[string]message {
+	c := make(map[Locale]map[string]message)
+	for _, l := range Supported {
+		data, err := localesFS.ReadFile(path.Join("locales", string(l)+".json"))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: missing catalogue for %s: %v", l, err))
+		}
+		messages := make(map[string]message)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalogue for %s: %v", l, err))
+		}
+		c[l] = messages
+	}
+	return c
+}
+
+// Parse matches a Telegram language_code (an IETF tag such as "ca" or
+// "es-419") against the supported locales.
+func Parse(code string) (Locale, bool) {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(code)), "-")
+	for _, l := range Supported {
+		if string(l) == base {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// Detect returns the locale for a Telegram language_code, or Default when
+// the language is not supported.
+func Detect(code string) Locale {
+	if l, ok := Parse(code); ok {
+		return l
+	}
+	return Default
+}
+
+// Name returns the name of the locale in its own language.
+func (l Locale) Name() string {
+	return T(l, "language.name")
+}
+
+// Lookup returns the translation for key without falling back to the key
+// itself, so callers can tell whether the catalogue has an entry.
+func Lookup(l Locale, key string) (string, bool) {
+	if m, ok := catalog[l][key]; ok {
+		return m.Other, true
+	}
+	if m, ok := catalog[Default][key]; ok {
+		return m.Other, true
+	}
+	return "", false
+}
+
+// T translates key and formats it with args.
+func T(l Locale, key string, args ...any) string {
+	m, ok := find(l, key)
+	if !ok {
+		return key
+	}
+	return format(m.Other, args)
+}
+
+// N translates key using the plural form for n and formats it with args.
+func N(l Locale, key string, n int, args ...any) string {
+	m, ok := find(l, key)
+	if !ok {
+		return key
+	}
+	text := m.Other
+	if pluralOne(l, n) && m.One != "" {
+		text = m.One
+	}
+	return format(text, args)
+}
+
+// pluralOne reports whether n takes the "one" form. Catalan, Spanish and
+// English share the same CLDR rule for integers.
+func pluralOne(_ Locale, n int) bool {
+	return n == 1
+}
+
+func find(l Locale, key string) (message, bool) {
+	if m, ok := catalog[l][key]; ok {
+		return m, true
+	}
+	if m, ok := catalog[Default][key]; ok {
+		return m, true
+	}
+	log.Printf("i18n: missing translation %q", key)
+	return message{}, false
+}
+
+func format(text string, args []any) string {
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+type contextKey struct{}
+
+// WithLocale returns a copy of ctx carrying the user's locale.
+func WithLocale(ctx context.Context, l Locale) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the locale stored in ctx, or Default.
+func FromContext(ctx context.Context) Locale {
+	if l, ok := ctx.Value(contextKey{}).(Locale); ok {
+		return l
+	}
+	return Default
+}
@@ -0,0 +1,226 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Class groups updates that share a concurrency limit.
+type Class string
+
+const (
+	// ClassRender is for updates that render a map.
+	ClassRender Class = "render"
+	// ClassText is for everything else.
+	ClassText Class = "text"
+)
+
+type HandlerFunc func(ctx context.Context, b *bot.Bot, update *models.Update)
+
+// Metrics receives the queue metrics. *newrelic.Application satisfies it.
+type Metrics interface {
+	RecordCustomMetric(name string, value float64)
+}
+
+type Config struct {
+	// Workers is the number of updates of each class handled at once.
+	// Classes without an entry get one worker.
+	Workers map[Class]int
+	// Capacity is the number of updates accepted but not yet handled before
+	// Submit blocks.
+	Capacity int
+}
+
+type job struct {
+	ctx      context.Context
+	b        *bot.Bot
+	update   *models.Update
+	class    Class
+	enqueued time.Time
+}
+
+// Queue runs updates in the background with bounded concurrency. Updates
+// from the same chat are handled one at a time, in the order they were
+// submitted.
+type Queue struct {
+	handle   HandlerFunc
+	classify func(*models.Update) Class
+	metrics  Metrics
+
+	slots   chan struct{}
+	workers map[Class]chan struct{}
+
+	mu      sync.Mutex
+	chats   map[int64][]job
+	pending map[Class]int
+	wg      sync.WaitGroup
+}
+
+func New(cfg Config, classify func(*models.Update) Class, handle HandlerFunc, metrics Metrics) *Queue {
+	q := &Queue{
+		handle:   handle,
+		classify: classify,
+		metrics:  metrics,
+		slots:    make(chan struct{}, max(cfg.Capacity, 1)),
+		workers:  make(map[Class]chan struct{}),
+		chats:    make(map[int64][]job),
+		pending:  make(map[Class]int),
+	}
+	for class, n := range cfg.Workers {
+		q.workers[class] = make(chan struct{}, max(n, 1))
+	}
+	return q
+}
+
+// Submit queues an update, blocking while the queue is full so that a burst
+// slows down how fast updates are fetched instead of piling up in memory.
+// It returns false if ctx is done first and the update was dropped.
+//
+// Updates that were accepted are handled with the values of ctx but not its
+// cancellation, so that they still run after the bot stops fetching updates.
+func (q *Queue) Submit(ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	j := job{ctx: context.WithoutCancel(ctx), b: b, update: update, class: q.classify(update), enqueued: time.Now()}
+	chatID := chatOf(update)
+
+	q.mu.Lock()
+	backlog, active := q.chats[chatID]
+	q.chats[chatID] = append(backlog, j)
+	q.pending[j.class]++
+	depth := q.pending[j.class]
+	q.mu.Unlock()
+
+	q.record("Queue/"+string(j.class)+"/Depth", float64(depth))
+	if !active {
+		q.wg.Add(1)
+		go q.runChat(chatID)
+	}
+	return true
+}
+
+// Wait blocks until every submitted update was handled or ctx is done. Stopping
+// Submit's caller does not cancel queued updates, so on shutdown Wait drains
+// the whole queue, bounded only by ctx.
+func (q *Queue) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runChat handles the backlog of one chat until it is empty.
+func (q *Queue) runChat(chatID int64) {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		backlog := q.chats[chatID]
+		if len(backlog) == 0 {
+			delete(q.chats, chatID)
+			q.mu.Unlock()
+			return
+		}
+		j := backlog[0]
+		q.mu.Unlock()
+
+		q.run(j)
+
+		q.mu.Lock()
+		q.chats[chatID] = q.chats[chatID][1:]
+		q.pending[j.class]--
+		q.mu.Unlock()
+		<-q.slots
+	}
+}
+
+// run handles j once a worker of its class is free. Accepted updates are
+// never dropped, so waiting for a worker is not cancelled either.
+func (q *Queue) run(j job) {
+	workers := q.workersFor(j.class)
+	workers <- struct{}{}
+	defer func() { <-workers }()
+
+	wait := time.Since(j.enqueued)
+	q.record("Queue/"+string(j.class)+"/WaitMs", float64(wait.Milliseconds()))
+	q.handle(withWait(j.ctx, wait), j.b, j.update)
+}
+
+// TryWorker takes a worker of class for work done outside the queue, such as
+// drawing a map for the API, so that it shares the class's limit. It returns
+// false when every worker is busy; otherwise release must be called once the
+// work is done.
+func (q *Queue) TryWorker(class Class) (release func(), ok bool) {
+	workers := q.workersFor(class)
+	select {
+	case workers <- struct{}{}:
+		return func() { <-workers }, true
+	default:
+		return nil, false
+	}
+}
+
+func (q *Queue) workersFor(class Class) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	workers, ok := q.workers[class]
+	if !ok {
+		workers = make(chan struct{}, 1)
+		q.workers[class] = workers
+	}
+	return workers
+}
+
+func (q *Queue) record(name string, value float64) {
+	if q.metrics != nil {
+		q.metrics.RecordCustomMetric(name, value)
+	}
+}
+
+// chatOf returns the chat an update belongs to, falling back to the sender
+// for updates without one, such as inline queries.
+func chatOf(update *models.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	case update.CallbackQuery != nil:
+		if m := update.CallbackQuery.Message.Message; m != nil {
+			return m.Chat.ID
+		}
+		if m := update.CallbackQuery.Message.InaccessibleMessage; m != nil {
+			return m.Chat.ID
+		}
+		return update.CallbackQuery.From.ID
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return update.InlineQuery.From.ID
+	}
+	return 0
+}
+
+type waitKey struct{}
+
+func withWait(ctx context.Context, wait time.Duration) context.Context {
+	return context.WithValue(ctx, waitKey{}, wait)
+}
+
+// WaitFromContext returns how long the update being handled was queued.
+func WaitFromContext(ctx context.Context) time.Duration {
+	wait, _ := ctx.Value(waitKey{}).(time.Duration)
+	return wait
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sweepInterval is how often expired state is dropped from a Memory.
+	sweepInterval = time.Minute
+	// maxBuckets makes a Memory drop idle buckets early, between sweeps.
+	maxBuckets = 10000
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type strikes struct {
+	count   int64
+	expires time.Time
+}
+
+// Memory is a Store kept in process memory, used when Redis is unavailable
+// and for limits that only need to hold within one replica.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	strikes   map[string]*strikes
+	bans      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets:   make(map[string]*bucket),
+		strikes:   make(map[string]*strikes),
+		bans:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *Memory) Take(_ context.Context, key string, p Policy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if until, ok := m.bans[key]; ok {
+		if now.Before(until) {
+			return Result{RetryAfter: until.Sub(now), Banned: true}, nil
+		}
+		delete(m.bans, key)
+	}
+
+	// Every client seen adds state, so it is swept regularly.
+	if now.Sub(m.lastSweep) >= sweepInterval || len(m.buckets) > maxBuckets {
+		m.evict(now)
+	}
+
+	id := p.Name + ":" + key
+	b, ok := m.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(p.Burst), last: now}
+		m.buckets[id] = b
+	}
+	b.tokens = math.Min(float64(p.Burst), b.tokens+now.Sub(b.last).Seconds()*p.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Result{Allowed: true}, nil
+	}
+	res := Result{RetryAfter: time.Duration((1 - b.tokens) / p.Rate * float64(time.Second))}
+	if p.Strikes == 0 {
+		return res, nil
+	}
+
+	s, ok := m.strikes[key]
+	if !ok || now.After(s.expires) {
+		s = &strikes{expires: now.Add(p.StrikeWindow)}
+		m.strikes[key] = s
+	}
+	s.count++
+	if s.count >= p.Strikes {
+		delete(m.strikes, key)
+		m.bans[key] = now.Add(p.BanDuration)
+		return Result{RetryAfter: p.BanDuration, Banned: true}, nil
+	}
+	return res, nil
+}
+
+func (m *Memory) LimitStatus(_ context.Context, key string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var status Status
+	if s, ok := m.strikes[key]; ok && now.Before(s.expires) {
+		status.Strikes = s.count
+	}
+	if until, ok := m.bans[key]; ok && now.Before(until) {
+		status.BanTTL = until.Sub(now)
+	}
+	return status, nil
+}
+
+func (m *Memory) LiftLimits(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.strikes, key)
+	delete(m.bans, key)
+	return nil
+}
+
+func (m *Memory) Bans(_ context.Context) ([]Ban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var bans []Ban
+	for key, until := range m.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{Key: key, TTL: until.Sub(now)})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Key < bans[j].Key })
+	return bans, nil
+}
+
+// evict drops expired strikes and bans, and the buckets that have refilled.
+// It relies on every bucket having been created full, so a bucket idle for
+// long enough is full again.
+func (m *Memory) evict(now time.Time) {
+	m.lastSweep = now
+	for id, b := range m.buckets {
+		if now.Sub(b.last) > time.Hour {
+			delete(m.buckets, id)
+		}
+	}
+	for key, s := range m.strikes {
+		if now.After(s.expires) {
+			delete(m.strikes, key)
+		}
+	}
+	for key, until := range m.bans {
+		if !now.Before(until) {
+			delete(m.bans, key)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Rate per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Policy is a named limit with optional temporary bans.
+type Policy struct {
+	// Name namespaces the buckets of the policy.
+	Name string
+	Limit
+	// Strikes rejected requests within StrikeWindow ban the key for
+	// BanDuration. Zero Strikes disables bans.
+	Strikes      int64
+	StrikeWindow time.Duration
+	BanDuration  time.Duration
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long to wait before the next request is allowed.
+	RetryAfter time.Duration
+	// Banned is set when the key is banned, RetryAfter being the time left.
+	Banned bool
+}
+
+// Status describes the limits a key is under.
+type Status struct {
+	Strikes int64
+	BanTTL  time.Duration
+}
+
+// Ban is a currently banned key.
+type Ban struct {
+	Key string
+	TTL time.Duration
+}
+
+// Store keeps token buckets, strikes and bans. Bans are shared by every
+// policy.
+type Store interface {
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+	LimitStatus(ctx context.Context, key string) (Status, error)
+	LiftLimits(ctx context.Context, key string) error
+	Bans(ctx context.Context) ([]Ban, error)
+}
+
+// Limiter applies a policy, falling back to an in-memory store when the
+// shared store fails so that an outage does not lock everyone out.
+type Limiter struct {
+	store    Store
+	fallback *Memory
+	policy   Policy
+	degraded atomic.Bool
+}
+
+// New returns a limiter for p. Limiters sharing fallback also share bans
+// while the store is down.
+func New(store Store, fallback *Memory, p Policy) *Limiter {
+	return &Limiter{store: store, fallback: fallback, policy: p}
+}
+
+// Allow reports whether key may be served now, and otherwise how long it has
+// to wait.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	res, err := l.store.Take(ctx, key, l.policy)
+	if l.failed(err) {
+		res, _ = l.fallback.Take(ctx, key, l.policy)
+	}
+	return res.Allowed, res.RetryAfter
+}
+
+func (l *Limiter) Status(ctx context.Context, key string) (Status, error) {
+	status, err := l.store.LimitStatus(ctx, key)
+	if l.failed(err) {
+		return l.fallback.LimitStatus(ctx, key)
+	}
+	return status, nil
+}
+
+// Lift clears the strikes and ban of key.
+func (l *Limiter) Lift(ctx context.Context, key string) error {
+	// The fallback may hold a ban from an earlier outage.
+	_ = l.fallback.LiftLimits(ctx, key)
+	return l.store.LiftLimits(ctx, key)
+}
+
+func (l *Limiter) Bans(ctx context.Context) ([]Ban, error) {
+	bans, err := l.store.Bans(ctx)
+	if l.failed(err) {
+		return l.fallback.Bans(ctx)
+	}
+	return bans, nil
+}
+
+// failed records whether the store works, logging when that changes.
+func (l *Limiter) failed(err error) bool {
+	if err != nil {
+		if !l.degraded.Swap(true) {
+			log.Printf("rate limit store failed, using in-memory limits: %v", err)
+		}
+		return true
+	}
+	if l.degraded.Swap(false) {
+		log.Printf("rate limit store recovered")
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package rendercache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired files are removed from a Disk store.
+const sweepInterval = 10 * time.Minute
+
+// Disk is a Store of files in a directory, for deployments without Redis to
+// spare. Each entry is an image file and, once uploaded, a file holding its
+// file ID. The modification time of both is set to when the entry expires.
+type Disk struct {
+	dir string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewDisk returns a store in dir, creating it if needed.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating render cache: %w", err)
+	}
+	return &Disk{dir: dir, lastSweep: time.Now()}, nil
+}
+
+func (d *Disk) path(key, ext string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+func (d *Disk) GetRender(ctx context.Context, key string) (Entry, error) {
+	path := d.path(key, ".img")
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Entry{}, ErrMiss
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	if time.Now().After(info.ModTime()) {
+		d.remove(key)
+		return Entry{}, ErrMiss
+	}
+
+	image, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Entry{}, ErrMiss
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	e := Entry{Image: image}
+	if id, err := os.ReadFile(d.path(key, ".id")); err == nil {
+		e.FileID = string(id)
+	}
+	return e, nil
+}
+
+func (d *Disk) PutRender(ctx context.Context, key string, e Entry, ttl time.Duration) error {
+	d.sweep()
+
+	expires := time.Now().Add(ttl)
+	if err := writeFile(d.path(key, ".img"), e.Image, expires); err != nil {
+		return err
+	}
+	os.Remove(d.path(key, ".id"))
+	if e.FileID != "" {
+		return writeFile(d.path(key, ".id"), []byte(e.FileID), expires)
+	}
+	return nil
+}
+
+func (d *Disk) SetRenderFileID(ctx context.Context, key, fileID string) error {
+	info, err := os.Stat(d.path(key, ".img"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return writeFile(d.path(key, ".id"), []byte(fileID), info.ModTime())
+}
+
+func (d *Disk) remove(key string) {
+	os.Remove(d.path(key, ".img"))
+	os.Remove(d.path(key, ".id"))
+}
+
+// sweep removes expired files, at most once per sweepInterval.
+func (d *Disk) sweep() {
+	d.mu.Lock()
+	if time.Since(d.lastSweep) < sweepInterval {
+		d.mu.Unlock()
+		return
+	}
+	d.lastSweep = time.Now()
+	d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Printf("error sweeping render cache: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); ext != ".img" && ext != ".id" && !strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && now.After(info.ModTime()) {
+			os.Remove(filepath.Join(d.dir, entry.Name()))
+		}
+	}
+}
+
+// writeFile writes data to path through a temporary file, so that readers
+// never see it half written, and sets its modification time to expires.
+func writeFile(path string, data []byte, expires time.Time) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(f.Name(), time.Now(), expires)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+// Package rendercache keeps rendered maps, and the Telegram file IDs they
+// were uploaded as, so that repeated searches from the same spot are neither
+// rendered nor uploaded again.
+package rendercache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrMiss is returned for maps that are not cached.
+var ErrMiss = errors.New("map not cached")
+
+// Entry is a cached map.
+type Entry struct {
+	// Image is nil for entries of stores that only keep file IDs.
+	Image []byte
+	// FileID is set once the image was sent to Telegram.
+	FileID string
+}
+
+// Store holds entries until their TTL runs out. A store may keep only the
+// file ID of entries, in which case entries are missed until they have one.
+type Store interface {
+	GetRender(ctx context.Context, key string) (Entry, error)
+	PutRender(ctx context.Context, key string, e Entry, ttl time.Duration) error
+	// SetRenderFileID records the file ID of a cached entry, keeping its TTL.
+	// Entries that expired in the meantime are left alone.
+	SetRenderFileID(ctx context.Context, key, fileID string) error
+}
+
+// Cache caches maps for searches within the same cell.
+type Cache struct {
+	store Store
+	ttl   time.Duration
+	cell  float64
+}
+
+// New returns a cache keeping maps for ttl in store. Maps are keyed by cells
+// of cellMetres.
+func New(store Store, ttl time.Duration, cellMetres float64) *Cache {
+	return &Cache{store: store, ttl: ttl, cell: cellMetres}
+}
+
+// Snap moves lat, lon to the centre of its cell, so that searches from
+// anywhere in the cell share a key.
+func (c *Cache) Snap(lat, lon float64) (float64, float64) {
+	const metresPerDegree = 6371008.8 * math.Pi / 180
+	dLat := c.cell / metresPerDegree
+	lat = (math.Floor(lat/dLat) + 0.5) * dLat
+	// Cells are as wide as they are tall at the latitude of their centre.
+	dLon := c.cell / (metresPerDegree * math.Cos(lat*math.Pi/180))
+	lon = (math.Floor(lon/dLon) + 0.5) * dLon
+	return lat, lon
+}
+
+// Key names the map of a search from the cell centred on lat, lon within
+// radius metres, in theme, on version of the bench dataset. digest tells
+// apart everything else that changes the map, see maps.Renderer.Digest.
+func Key(version int64, theme string, lat, lon, radius float64, digest string) string {
+	return fmt.Sprintf("v%d:%s:%.5f,%.5f:%g:%s", version, theme, lat, lon, radius, digest[:min(16, len(digest))])
+}
+
+// Get returns the cached map under key, or ErrMiss.
+func (c *Cache) Get(ctx context.Context, key string) (Entry, error) {
+	return c.store.GetRender(ctx, key)
+}
+
+// Put caches a map under key.
+func (c *Cache) Put(ctx context.Context, key string, e Entry) error {
+	return c.store.PutRender(ctx, key, e, c.ttl)
+}
+
+// SetFileID records that the map under key was uploaded as fileID.
+func (c *Cache) SetFileID(ctx context.Context, key, fileID string) error {
+	return c.store.SetRenderFileID(ctx, key, fileID)
+}
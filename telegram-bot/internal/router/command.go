@@ -0,0 +1,61 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// Command is a bot command such as "/update_benches@where_is_my_bench_bot now".
+type Command struct {
+	// Name is the lower-cased command without the leading slash.
+	Name string
+	// Mention is the bot name after "@", if any.
+	Mention string
+	// Args is the rest of the message, trimmed.
+	Args string
+}
+
+// ParseCommand parses the command at the start of a message text or, for
+// media messages, of its caption.
+func ParseCommand(m *models.Message) (Command, bool) {
+	if m == nil {
+		return Command{}, false
+	}
+	text := m.Text
+	if text == "" {
+		text = m.Caption
+	}
+	if !strings.HasPrefix(text, "/") {
+		return Command{}, false
+	}
+
+	token, args := text[1:], ""
+	if i := strings.IndexFunc(token, unicode.IsSpace); i >= 0 {
+		token, args = token[:i], token[i:]
+	}
+	name, mention, _ := strings.Cut(token, "@")
+	if name == "" {
+		return Command{}, false
+	}
+
+	return Command{
+		Name:    strings.ToLower(name),
+		Mention: mention,
+		Args:    strings.TrimSpace(args),
+	}, true
+}
+
+type commandKey struct{}
+
+func withCommand(ctx context.Context, c Command) context.Context {
+	return context.WithValue(ctx, commandKey{}, c)
+}
+
+// CommandFromContext returns the command being handled, if the update is one.
+func CommandFromContext(ctx context.Context) (Command, bool) {
+	c, ok := ctx.Value(commandKey{}).(Command)
+	return c, ok
+}
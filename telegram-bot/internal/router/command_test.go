@@ -0,0 +1,41 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *models.Message
+		want Command
+		ok   bool
+	}{
+		{"nil message", nil, Command{}, false},
+		{"plain text", &models.Message{Text: "hello"}, Command{}, false},
+		{"bare slash", &models.Message{Text: "/"}, Command{}, false},
+		{"mention only", &models.Message{Text: "/@where_is_my_bench_bot"}, Command{}, false},
+		{"command", &models.Message{Text: "/start"}, Command{Name: "start"}, true},
+		{"upper case", &models.Message{Text: "/Start"}, Command{Name: "start"}, true},
+		{"arguments", &models.Message{Text: "/limits 42 lift"}, Command{Name: "limits", Args: "42 lift"}, true},
+		{"arguments trimmed", &models.Message{Text: "/export   gpx  "}, Command{Name: "export", Args: "gpx"}, true},
+		{"arguments on a new line", &models.Message{Text: "/report\nbroken"}, Command{Name: "report", Args: "broken"}, true},
+		{"mention", &models.Message{Text: "/update_benches@where_is_my_bench_bot"},
+			Command{Name: "update_benches", Mention: "where_is_my_bench_bot"}, true},
+		{"mention and arguments", &models.Message{Text: "/heatmap@where_is_my_bench_bot Gràcia"},
+			Command{Name: "heatmap", Mention: "where_is_my_bench_bot", Args: "Gràcia"}, true},
+		{"caption", &models.Message{Caption: "/addbench"}, Command{Name: "addbench"}, true},
+		{"text before caption", &models.Message{Text: "hello", Caption: "/addbench"}, Command{}, false},
+		{"slash later in text", &models.Message{Text: "see /help"}, Command{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseCommand(tt.msg)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseCommand() = %+v, %t, want %+v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
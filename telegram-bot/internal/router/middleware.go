@@ -0,0 +1,132 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// Recover stops a panicking handler from taking the whole bot down. The
+// panic is reported to New Relic with the "panic" class, then onPanic, if set,
+// can tell the user their request failed.
+func Recover(onPanic HandlerFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				route := RouteFromContext(ctx)
+				newrelic.FromContext(ctx).NoticeError(newrelic.Error{
+					Message:    fmt.Sprintf("panic in %s %s: %v", route.Kind, route.Name, p),
+					Class:      "panic",
+					Attributes: map[string]interface{}{"operation": route.Name},
+				})
+				log.Printf("panic handling update %d (%s %s): %v\n%s", update.ID, route.Kind, route.Name, p, debug.Stack())
+
+				if onPanic != nil {
+					// A panic while apologising must not escape either.
+					defer func() { _ = recover() }()
+					onPanic(ctx, b, update)
+				}
+			}()
+			next(ctx, b, update)
+		}
+	}
+}
+
+// Logger logs every routed update and how long it took.
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			start := time.Now()
+			next(ctx, b, update)
+			route := RouteFromContext(ctx)
+			log.Printf("update %d: %s %s handled in %s", update.ID, route.Kind, route.Name, time.Since(start))
+		}
+	}
+}
+
+// Tracing names the New Relic transaction after the route and wraps the
+// handler in a segment.
+func Tracing() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			txn := newrelic.FromContext(ctx)
+			route := RouteFromContext(ctx)
+			txn.SetName(fmt.Sprintf("bot_update/%s/%s", route.Kind, route.Name))
+			txn.AddAttribute("update_type", route.Kind)
+			txn.AddAttribute("route", route.Name)
+
+			segment := txn.StartSegment("route." + route.Name)
+			defer segment.End()
+			next(ctx, b, update)
+		}
+	}
+}
+
+// Limiter decides whether key may be served now, and otherwise how long it
+// has to wait.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration)
+}
+
+// KeyFunc returns the key an update is limited under, or "" to not limit it.
+type KeyFunc func(ctx context.Context, update *models.Update) string
+
+// LimitedFunc handles an update that was over its limit.
+type LimitedFunc func(ctx context.Context, b *bot.Bot, update *models.Update, retryAfter time.Duration)
+
+// RateLimit drops updates over their limit, calling onLimited instead when it
+// is set.
+func RateLimit(l Limiter, key KeyFunc, onLimited LimitedFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			k := key(ctx, update)
+			if k == "" {
+				next(ctx, b, update)
+				return
+			}
+			ok, retryAfter := l.Allow(ctx, k)
+			if ok {
+				next(ctx, b, update)
+				return
+			}
+			newrelic.FromContext(ctx).AddAttribute("rate_limited", k)
+			if onLimited != nil {
+				onLimited(ctx, b, update, retryAfter)
+			}
+		}
+	}
+}
+
+// SenderKey limits updates per sender. Updates without a sender are not
+// limited.
+func SenderKey(_ context.Context, update *models.Update) string {
+	if from := Sender(update); from != nil {
+		return fmt.Sprintf("user:%d", from.ID)
+	}
+	return ""
+}
+
+// Sender returns the user who sent the update, if any.
+func Sender(update *models.Update) *models.User {
+	switch {
+	case update.Message != nil:
+		return update.Message.From
+	case update.EditedMessage != nil:
+		return update.EditedMessage.From
+	case update.CallbackQuery != nil:
+		return &update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package router
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+type HandlerFunc func(ctx context.Context, b *bot.Bot, update *models.Update)
+
+// Middleware wraps a handler with behaviour shared by several routes.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// MatchFunc reports whether a message route handles the message.
+type MatchFunc func(m *models.Message) bool
+
+// Kinds of updates the router dispatches.
+const (
+	KindCommand       = "command"
+	KindMessage       = "message"
+	KindEditedMessage = "edited_message"
+	KindCallback      = "callback"
+	KindInlineQuery   = "inline_query"
+)
+
+// Route identifies the route an update was dispatched to.
+type Route struct {
+	Kind string
+	Name string
+}
+
+type routeKey struct{}
+
+// RouteFromContext returns the route being handled.
+func RouteFromContext(ctx context.Context) Route {
+	r, _ := ctx.Value(routeKey{}).(Route)
+	return r
+}
+
+type prefixRoute struct {
+	prefix  string
+	handler HandlerFunc
+}
+
+type matchRoute struct {
+	name    string
+	match   MatchFunc
+	handler HandlerFunc
+}
+
+// Router dispatches updates to the registered routes. Every update goes
+// through the middleware added with Use, in order, before the route's own
+// middleware.
+type Router struct {
+	botUsername string
+	middleware  []Middleware
+	commands    map[string]HandlerFunc
+	callbacks   []prefixRoute
+	messages    []matchRoute
+	edited      []matchRoute
+	inline      HandlerFunc
+}
+
+// New creates a router for the bot with the given username, so that commands
+// addressed to other bots in group chats are ignored.
+func New(botUsername string) *Router {
+	return &Router{
+		botUsername: botUsername,
+		commands:    make(map[string]HandlerFunc),
+	}
+}
+
+// Use appends middleware to the chain every route passes through.
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Command routes "/name" messages, with or without arguments and bot name.
+func (r *Router) Command(name string, h HandlerFunc, mw ...Middleware) {
+	r.commands[strings.ToLower(name)] = chain(h, mw)
+}
+
+// Callback routes callback queries whose data starts with prefix. Prefixes
+// are tried in registration order.
+func (r *Router) Callback(prefix string, h HandlerFunc, mw ...Middleware) {
+	r.callbacks = append(r.callbacks, prefixRoute{prefix: prefix, handler: chain(h, mw)})
+}
+
+// Message routes non-command messages accepted by match. Routes are tried in
+// registration order.
+func (r *Router) Message(name string, match MatchFunc, h HandlerFunc, mw ...Middleware) {
+	r.messages = append(r.messages, matchRoute{name: name, match: match, handler: chain(h, mw)})
+}
+
+// EditedMessage routes edited messages accepted by match.
+func (r *Router) EditedMessage(name string, match MatchFunc, h HandlerFunc, mw ...Middleware) {
+	r.edited = append(r.edited, matchRoute{name: name, match: match, handler: chain(h, mw)})
+}
+
+// InlineQuery routes all inline queries.
+func (r *Router) InlineQuery(h HandlerFunc, mw ...Middleware) {
+	r.inline = chain(h, mw)
+}
+
+// Handle dispatches an update. Updates no route accepts are dropped.
+func (r *Router) Handle(ctx context.Context, b *bot.Bot, update *models.Update) {
+	route, h, ok := r.resolve(update)
+	if !ok {
+		return
+	}
+	if route.Kind == KindCommand {
+		c, _ := ParseCommand(update.Message)
+		ctx = withCommand(ctx, c)
+	}
+	ctx = context.WithValue(ctx, routeKey{}, route)
+	chain(h, r.middleware)(ctx, b, update)
+}
+
+func (r *Router) resolve(update *models.Update) (Route, HandlerFunc, bool) {
+	switch {
+	case update.Message != nil:
+		if c, ok := ParseCommand(update.Message); ok {
+			if c.Mention != "" && !strings.EqualFold(c.Mention, r.botUsername) {
+				return Route{}, nil, false
+			}
+			if h, ok := r.commands[c.Name]; ok {
+				return Route{Kind: KindCommand, Name: c.Name}, h, true
+			}
+		}
+		return matchMessage(KindMessage, r.messages, update.Message)
+	case update.EditedMessage != nil:
+		return matchMessage(KindEditedMessage, r.edited, update.EditedMessage)
+	case update.CallbackQuery != nil:
+		for _, route := range r.callbacks {
+			if strings.HasPrefix(update.CallbackQuery.Data, route.prefix) {
+				return Route{Kind: KindCallback, Name: strings.TrimSuffix(route.prefix, ":")}, route.handler, true
+			}
+		}
+	case update.InlineQuery != nil && r.inline != nil:
+		return Route{Kind: KindInlineQuery, Name: "inline"}, r.inline, true
+	}
+	return Route{}, nil, false
+}
+
+func matchMessage(kind string, routes []matchRoute, m *models.Message) (Route, HandlerFunc, bool) {
+	for _, route := range routes {
+		if route.match(m) {
+			return Route{Kind: kind, Name: route.name}, route.handler, true
+		}
+	}
+	return Route{}, nil, false
+}
+
+// chain wraps h so that mw[0] runs first.
+func chain(h HandlerFunc, mw []Middleware) HandlerFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
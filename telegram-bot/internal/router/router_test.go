@@ -0,0 +1,124 @@
+package router
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// record returns middleware appending name to calls when it runs.
+func record(calls *[]string, name string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			*calls = append(*calls, name)
+			next(ctx, b, update)
+		}
+	}
+}
+
+func TestRouterMiddlewareOrder(t *testing.T) {
+	var calls []string
+	r := New("where_is_my_bench_bot")
+	r.Use(record(&calls, "global 1"), record(&calls, "global 2"))
+	r.Command("start", func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		calls = append(calls, "handler")
+	}, record(&calls, "route 1"), record(&calls, "route 2"))
+
+	r.Handle(context.Background(), nil, &models.Update{Message: &models.Message{Text: "/start"}})
+
+	want := []string{"global 1", "global 2", "route 1", "route 2", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRouterCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		update    *models.Update
+		wantRoute Route
+		wantArgs  string
+	}{
+		{
+			name:      "command",
+			update:    &models.Update{Message: &models.Message{Text: "/export gpx"}},
+			wantRoute: Route{Kind: KindCommand, Name: "export"},
+			wantArgs:  "gpx",
+		},
+		{
+			name:      "addressed to this bot",
+			update:    &models.Update{Message: &models.Message{Text: "/Export@Where_Is_My_Bench_Bot kml"}},
+			wantRoute: Route{Kind: KindCommand, Name: "export"},
+			wantArgs:  "kml",
+		},
+		{
+			name:   "addressed to another bot",
+			update: &models.Update{Message: &models.Message{Text: "/export@other_bot"}},
+		},
+		{
+			name:      "caption",
+			update:    &models.Update{Message: &models.Message{Caption: "/export geojson", Photo: []models.PhotoSize{{FileID: "x"}}}},
+			wantRoute: Route{Kind: KindCommand, Name: "export"},
+			wantArgs:  "geojson",
+		},
+		{
+			name:      "unknown command",
+			update:    &models.Update{Message: &models.Message{Text: "/nope"}},
+			wantRoute: Route{Kind: KindMessage, Name: "text"},
+		},
+		{
+			name:      "callback",
+			update:    &models.Update{CallbackQuery: &models.CallbackQuery{Data: "export:41.38740,2.16860"}},
+			wantRoute: Route{Kind: KindCallback, Name: "export"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotRoute Route
+				gotArgs  string
+			)
+			h := func(ctx context.Context, b *bot.Bot, update *models.Update) {
+				gotRoute = RouteFromContext(ctx)
+				if c, ok := CommandFromContext(ctx); ok {
+					gotArgs = c.Args
+				}
+			}
+			r := New("where_is_my_bench_bot")
+			r.Command("export", h)
+			r.Callback("export:", h)
+			r.Message("text", func(m *models.Message) bool { return m.Text != "" }, h)
+
+			r.Handle(context.Background(), nil, tt.update)
+
+			if gotRoute != tt.wantRoute || gotArgs != tt.wantArgs {
+				t.Errorf("routed to %+v with args %q, want %+v with %q", gotRoute, gotArgs, tt.wantRoute, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestSender(t *testing.T) {
+	user := &models.User{ID: 7}
+	tests := []struct {
+		name   string
+		update *models.Update
+		want   *models.User
+	}{
+		{"message", &models.Update{Message: &models.Message{From: user}}, user},
+		{"message from a chat", &models.Update{Message: &models.Message{SenderChat: &models.Chat{ID: -100}}}, nil},
+		{"callback", &models.Update{CallbackQuery: &models.CallbackQuery{From: *user}}, user},
+		{"nothing", &models.Update{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Sender(tt.update)
+			if (got == nil) != (tt.want == nil) || got != nil && got.ID != tt.want.ID {
+				t.Errorf("Sender() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
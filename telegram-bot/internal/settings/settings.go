@@ -0,0 +1,126 @@
+package settings
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+type Units string
+
+const (
+	Metric   Units = "metric"
+	Imperial Units = "imperial"
+)
+
+var UnitOptions = []Units{Metric, Imperial}
+
+// Layer is an optional overlay on the nearby search results.
+type Layer string
+
+const (
+	LayerCommunity Layer = "community"
+	LayerShade     Layer = "shade"
+	LayerReports   Layer = "reports"
+)
+
+var Layers = []Layer{LayerCommunity, LayerShade, LayerReports}
+
+// RadiusOptions and ResultCountOptions are the values offered in /settings.
+var (
+	RadiusOptions      = []float64{100, 250, 500, 1000}
+	ResultCountOptions = []int{5, 10, 15, 20}
+)
+
+// Settings are the preferences of a Telegram user.
+type Settings struct {
+	// Language is empty until the user picks one, in which case the language
+	// of their Telegram client is used.
+	Language string
+	Radius   float64
+	Layers   map[Layer]bool
+	MapStyle maps.Style
+	// Theme names one of the map themes. Unknown themes, such as one removed
+	// from the themes file, fall back to the default theme.
+	Theme       string
+	Units       Units
+	ResultCount int
+}
+
+// Default returns the settings of a user who never changed them.
+func Default() Settings {
+	return Settings{
+		Radius:      250,
+		Layers:      map[Layer]bool{LayerCommunity: true, LayerShade: true, LayerReports: true},
+		MapStyle:    maps.StyleStandard,
+		Theme:       maps.ThemeDefault,
+		Units:       Metric,
+		ResultCount: 10,
+	}
+}
+
+func (s Settings) HasLayer(l Layer) bool {
+	return s.Layers[l]
+}
+
+// FormatDistance formats a distance in meters in the user's units.
+func (s Settings) FormatDistance(meters float64) string {
+	if s.Units == Imperial {
+		feet := meters * 3.28084
+		if feet < 1000 {
+			return fmt.Sprintf("%.0f ft", feet)
+		}
+		return fmt.Sprintf("%.1f mi", meters/1609.344)
+	}
+	if meters < 1000 {
+		return fmt.Sprintf("%.0f m", meters)
+	}
+	return fmt.Sprintf("%.1f km", meters/1000)
+}
+
+func ValidRadius(r float64) bool {
+	for _, option := range RadiusOptions {
+		if r == option {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidResultCount(n int) bool {
+	for _, option := range ResultCountOptions {
+		if n == option {
+			return true
+		}
+	}
+	return false
+}
+
+func (u Units) Valid() bool {
+	return u == Metric || u == Imperial
+}
+
+func (l Layer) Valid() bool {
+	for _, layer := range Layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithSettings returns a copy of ctx carrying the user's settings.
+func WithSettings(ctx context.Context, s Settings) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the settings stored in ctx, or the defaults.
+func FromContext(ctx context.Context) Settings {
+	if s, ok := ctx.Value(contextKey{}).(Settings); ok {
+		return s
+	}
+	return Default()
+}
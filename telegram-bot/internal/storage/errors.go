@@ -0,0 +1,15 @@
+package storage
+
+import "errors"
+
+// Errors returned by storage implementations, wrapped with the failing
+// operation. Callers match them with errors.Is.
+var (
+	// ErrNotFound means the requested record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the record is not in a state the operation allows.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable means the backend could not be reached. Retrying later
+	// may succeed.
+	ErrUnavailable = errors.New("storage unavailable")
+)
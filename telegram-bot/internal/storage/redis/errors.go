@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+)
+
+// wrapErr tags err with op and the matching storage error.
+func wrapErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, redis.Nil):
+		return fmt.Errorf("%s: %w", op, storage.ErrNotFound)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%s: %w", op, err)
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrConflict), errors.Is(err, storage.ErrUnavailable):
+		return err
+	}
+
+	// Replies from the server are errors in the command, anything else is a
+	// connection problem.
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return fmt.Errorf("%s: %w: %w", op, storage.ErrUnavailable, err)
+}
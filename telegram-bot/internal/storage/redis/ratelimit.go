@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/ratelimit"
+)
+
+const (
+	rateLimitBanPrefix     = "ratelimit:ban:"
+	rateLimitStrikesPrefix = "ratelimit:strikes:"
+)
+
+// takeScript checks the ban, takes a token and counts the strike in one
+// round trip, so replicas sharing Redis cannot race each other. Time comes
+// from Redis so clock skew between replicas does not matter.
+//
+// KEYS: bucket, ban, strikes
+// ARGV: rate, burst, strikes, strike window ms, ban ms
+// Returns {allowed, retry after ms, banned}.
+var takeScript = redis.NewScript(`
+local ban = redis.call('PTTL', KEYS[2])
+if ban > 0 then
+	return {0, ban, 1}
+end
+
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+
+local limit = tonumber(ARGV[3])
+if allowed == 1 or limit == 0 then
+	return {allowed, wait, 0}
+end
+
+local strikes = redis.call('INCR', KEYS[3])
+if strikes == 1 then
+	redis.call('PEXPIRE', KEYS[3], ARGV[4])
+end
+if strikes >= limit then
+	redis.call('SET', KEYS[2], 1, 'PX', ARGV[5])
+	redis.call('DEL', KEYS[3])
+	return {0, tonumber(ARGV[5]), 1}
+end
+return {0, wait, 0}
+`)
+
+// Take takes a token for key under policy p.
+func (s *BenchStore) Take(ctx context.Context, key string, p ratelimit.Policy) (ratelimit.Result, error) {
+	keys := []string{"ratelimit:" + p.Name + ":" + key, rateLimitBanPrefix + key, rateLimitStrikesPrefix + key}
+	res, err := takeScript.Run(ctx, s.rdb, keys,
+		p.Rate, p.Burst, p.Strikes, p.StrikeWindow.Milliseconds(), p.BanDuration.Milliseconds()).Int64Slice()
+	if err != nil {
+		return ratelimit.Result{}, wrapErr("Take", err)
+	}
+	return ratelimit.Result{
+		Allowed:    res[0] == 1,
+		RetryAfter: time.Duration(res[1]) * time.Millisecond,
+		Banned:     res[2] == 1,
+	}, nil
+}
+
+// LimitStatus returns the strikes and remaining ban of key.
+func (s *BenchStore) LimitStatus(ctx context.Context, key string) (ratelimit.Status, error) {
+	pipe := s.rdb.Pipeline()
+	strikes := pipe.Get(ctx, rateLimitStrikesPrefix+key)
+	ban := pipe.PTTL(ctx, rateLimitBanPrefix+key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return ratelimit.Status{}, wrapErr("LimitStatus", err)
+	}
+
+	var status ratelimit.Status
+	status.Strikes, _ = strikes.Int64()
+	if ttl := ban.Val(); ttl > 0 {
+		status.BanTTL = ttl
+	}
+	return status, nil
+}
+
+// LiftLimits removes the ban and strikes of key.
+func (s *BenchStore) LiftLimits(ctx context.Context, key string) error {
+	return wrapErr("LiftLimits", s.rdb.Del(ctx, rateLimitBanPrefix+key, rateLimitStrikesPrefix+key).Err())
+}
+
+// Bans lists the keys currently banned.
+func (s *BenchStore) Bans(ctx context.Context) ([]ratelimit.Ban, error) {
+	var keys []string
+	iter := s.rdb.Scan(ctx, 0, rateLimitBanPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, wrapErr("Bans", err)
+	}
+
+	pipe := s.rdb.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, wrapErr("Bans", err)
+	}
+
+	var bans []ratelimit.Ban
+	for i, key := range keys {
+		// The ban may have expired since the scan.
+		if ttl := ttls[i].Val(); ttl > 0 {
+			bans = append(bans, ratelimit.Ban{Key: strings.TrimPrefix(key, rateLimitBanPrefix), TTL: ttl})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Key < bans[j].Key })
+	return bans, nil
+}
@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+func benchRatingsKey(gisID string) string {
+	return fmt.Sprintf("ratings:bench:%s", gisID)
+}
+
+func ratingSummaryKey(gisID string) string {
+	return fmt.Sprintf("ratings:summary:%s", gisID)
+}
+
+// rateRetries bounds how often RateBench retries when the user's rating
+// changes while it is being stored.
+const rateRetries = 3
+
+// RateBench stores the user's rating of a bench, replacing any previous one,
+// and keeps the per bench summary in sync. Only benches that exist can be
+// rated.
+func (s *BenchStore) RateBench(ctx context.Context, rating bench.Rating) error {
+	ratingsKey := benchRatingsKey(rating.GisID)
+	summaryKey := ratingSummaryKey(rating.GisID)
+	field := strconv.FormatInt(rating.UserID, 10)
+
+	rate := func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, benchHashKey(rating.GisID)).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("RateBench %s: %w", rating.GisID, storage.ErrNotFound)
+		}
+
+		var previous *bench.Rating
+		val, err := tx.HGet(ctx, ratingsKey, field).Result()
+		switch {
+		case err == nil:
+			decoded := decodeRating(val)
+			previous = &decoded
+		case err != redis.Nil:
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, ratingsKey, field, encodeRating(rating))
+			if previous == nil {
+				pipe.HIncrBy(ctx, summaryKey, "count", 1)
+				pipe.HIncrBy(ctx, summaryKey, "sum", int64(rating.Stars))
+			} else {
+				pipe.HIncrBy(ctx, summaryKey, "sum", int64(rating.Stars-previous.Stars))
+				for _, tag := range previous.Tags {
+					pipe.HIncrBy(ctx, summaryKey, "tag:"+string(tag), -1)
+				}
+			}
+			for _, tag := range rating.Tags {
+				pipe.HIncrBy(ctx, summaryKey, "tag:"+string(tag), 1)
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for range rateRetries {
+		err = s.rdb.Watch(ctx, rate, ratingsKey, benchHashKey(rating.GisID))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	return wrapErr("RateBench", err)
+}
+
+// GetRating returns the user's rating of a bench, or nil if they have not rated it.
+func (s *BenchStore) GetRating(ctx context.Context, gisID string, userID int64) (*bench.Rating, error) {
+	val, err := s.rdb.HGet(ctx, benchRatingsKey(gisID), strconv.FormatInt(userID, 10)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapErr("GetRating", err)
+	}
+
+	rating := decodeRating(val)
+	rating.GisID = gisID
+	rating.UserID = userID
+	return &rating, nil
+}
+
+// RatingSummaries returns the rating aggregates of the given benches. Benches
+// without ratings are left out of the map.
+func (s *BenchStore) RatingSummaries(ctx context.Context, gisIDs []string) (map[string]bench.RatingSummary, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(gisIDs))
+	for i, gisID := range gisIDs {
+		cmds[i] = pipe.HGetAll(ctx, ratingSummaryKey(gisID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, wrapErr("RatingSummaries", err)
+	}
+
+	summaries := make(map[string]bench.RatingSummary)
+	for i, cmd := range cmds {
+		data := cmd.Val()
+		if len(data) == 0 {
+			continue
+		}
+
+		summary := bench.RatingSummary{Tags: make(map[bench.RatingTag]int)}
+		for field, val := range data {
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				continue
+			}
+			switch {
+			case field == "count":
+				summary.Count = n
+			case field == "sum":
+				summary.Sum = n
+			case strings.HasPrefix(field, "tag:"):
+				summary.Tags[bench.RatingTag(strings.TrimPrefix(field, "tag:"))] = n
+			}
+		}
+		if summary.Count > 0 {
+			summaries[gisIDs[i]] = summary
+		}
+	}
+	return summaries, nil
+}
+
+// encodeRating stores a rating as "stars|tag,tag".
+func encodeRating(rating bench.Rating) string {
+	tags := make([]string, len(rating.Tags))
+	for i, tag := range rating.Tags {
+		tags[i] = string(tag)
+	}
+	return fmt.Sprintf("%d|%s", rating.Stars, strings.Join(tags, ","))
+}
+
+func decodeRating(val string) bench.Rating {
+	var rating bench.Rating
+	stars, tags, _ := strings.Cut(val, "|")
+	if n, err := strconv.Atoi(stars); err == nil {
+		rating.Stars = n
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag != "" {
+			rating.Tags = append(rating.Tags, bench.RatingTag(tag))
+		}
+	}
+	return rating
+}
@@ -2,14 +2,54 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
 )
 
-const benchesKey = "benches"
+const (
+	benchesKey = "benches"
+	// communityBenchesKey holds user submitted benches. It is kept apart from
+	// benchesKey so that reloading the city dataset does not drop them.
+	communityBenchesKey = "benches:community"
+	// datasetVersionKey is bumped whenever benches are added or removed, so
+	// that maps rendered from the old set are not served from the cache.
+	datasetVersionKey = "benches:version"
+)
+
+func benchHashKey(gisID string) string {
+	return fmt.Sprintf("bench:%s", gisID)
+}
+
+func benchFields(b bench.Bench) map[string]interface{} {
+	return map[string]interface{}{
+		"type":              b.Type,
+		"code":              b.Code,
+		"description":       b.Description,
+		"manufacturer":      b.Manufacturer,
+		"district_code":     b.DistrictCode,
+		"district_name":     b.DistrictName,
+		"neighborhood_code": b.NeighborhoodCode,
+		"neighborhood_name": b.NeighborhoodName,
+		"zone":              b.Zone,
+		"street_name":       b.StreetName,
+		"street_number":     b.StreetNumber,
+		"x_etrs89":          b.XETRS89,
+		"y_etrs89":          b.YETRS89,
+		"geometry_etrs89":   b.GeometryETRS89,
+		"longitude":         b.Longitude,
+		"latitude":          b.Latitude,
+		"geometry_wgs84":    b.GeometryWGS84,
+		"created_at":        b.CreatedAt,
+		"deleted_at":        b.DeletedAt,
+		"community":         b.Community,
+	}
+}
 
 type BenchStore struct {
 	rdb *redis.Client
@@ -35,71 +75,225 @@ func (s *BenchStore) StoreBenches(ctx context.Context, benches []bench.Bench) er
 		})
 
 		// Store complete bench data in hash
-		benchKey := fmt.Sprintf("bench:%s", b.GisID)
-		pipe.HSet(ctx, benchKey, map[string]interface{}{
-			"type":              b.Type,
-			"code":              b.Code,
-			"description":       b.Description,
-			"manufacturer":      b.Manufacturer,
-			"district_code":     b.DistrictCode,
-			"district_name":     b.DistrictName,
-			"neighborhood_code": b.NeighborhoodCode,
-			"neighborhood_name": b.NeighborhoodName,
-			"zone":              b.Zone,
-			"street_name":       b.StreetName,
-			"street_number":     b.StreetNumber,
-			"x_etrs89":          b.XETRS89,
-			"y_etrs89":          b.YETRS89,
-			"geometry_etrs89":   b.GeometryETRS89,
-			"longitude":         b.Longitude,
-			"latitude":          b.Latitude,
-			"geometry_wgs84":    b.GeometryWGS84,
-			"created_at":        b.CreatedAt,
-			"deleted_at":        b.DeletedAt,
-		})
+		pipe.HSet(ctx, benchHashKey(b.GisID), benchFields(b))
 	}
+	pipe.Incr(ctx, datasetVersionKey)
 	_, err := pipe.Exec(ctx)
-	return err
+	return wrapErr("StoreBenches", err)
 }
 
+// deleteRetries bounds how often DeleteAllBenches retries when benches are
+// added while it runs.
+const deleteRetries = 3
+
+// DeleteAllBenches removes the city benches and their details, leaving the
+// community benches alone.
 func (s *BenchStore) DeleteAllBenches(ctx context.Context) error {
-	_, err := s.rdb.Del(ctx, benchesKey).Result()
-	return err
+	del := func(tx *redis.Tx) error {
+		ids, err := tx.ZRange(ctx, benchesKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, benchesKey)
+			for _, id := range ids {
+				pipe.Del(ctx, benchHashKey(id))
+			}
+			pipe.Incr(ctx, datasetVersionKey)
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for range deleteRetries {
+		err = s.rdb.Watch(ctx, del, benchesKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	return wrapErr("DeleteAllBenches", err)
+}
+
+// DatasetVersion returns a number that changes whenever benches are added or
+// removed.
+func (s *BenchStore) DatasetVersion(ctx context.Context) (int64, error) {
+	v, err := s.rdb.Get(ctx, datasetVersionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, wrapErr("DatasetVersion", err)
 }
 
+// FindNearby returns the city and community benches within radiusMeters,
+// closest first.
 func (s *BenchStore) FindNearby(ctx context.Context, lat, lon float64, radiusMeters float64) ([]bench.Bench, error) {
-	locs, err := s.rdb.GeoRadius(ctx, benchesKey, lon, lat, &redis.GeoRadiusQuery{
-		Radius: radiusMeters,
-		Unit:   "m",
-		Sort:   "ASC",
-	}).Result()
-	if err != nil {
-		return nil, err
+	query := &redis.GeoRadiusQuery{
+		Radius:   radiusMeters,
+		Unit:     "m",
+		WithDist: true,
+		Sort:     "ASC",
 	}
 
-	benches := make([]bench.Bench, len(locs))
-	for i, loc := range locs {
-		benches[i] = bench.Bench{
+	pipe := s.rdb.Pipeline()
+	cityCmd := pipe.GeoRadius(ctx, benchesKey, lon, lat, query)
+	communityCmd := pipe.GeoRadius(ctx, communityBenchesKey, lon, lat, query)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, wrapErr("FindNearby", err)
+	}
+
+	city, err := cityCmd.Result()
+	if err != nil && err != redis.Nil {
+		return nil, wrapErr("FindNearby", err)
+	}
+	community, err := communityCmd.Result()
+	if err != nil && err != redis.Nil {
+		return nil, wrapErr("FindNearby", err)
+	}
+
+	benches := make([]bench.Bench, 0, len(city)+len(community))
+	for _, loc := range city {
+		benches = append(benches, bench.Bench{
 			GisID:     loc.Name,
 			Longitude: loc.Longitude,
 			Latitude:  loc.Latitude,
-		}
+			Distance:  loc.Dist,
+		})
+	}
+	for _, loc := range community {
+		benches = append(benches, bench.Bench{
+			GisID:     loc.Name,
+			Longitude: loc.Longitude,
+			Latitude:  loc.Latitude,
+			Community: true,
+			Distance:  loc.Dist,
+		})
 	}
 
+	sort.SliceStable(benches, func(i, j int) bool {
+		return benches[i].Distance < benches[j].Distance
+	})
+
 	return benches, nil
 }
 
+// StoreCommunityBench adds an approved user submission to the community layer.
+func (s *BenchStore) StoreCommunityBench(ctx context.Context, b bench.Bench) error {
+	pipe := s.rdb.TxPipeline()
+	addCommunityBench(ctx, pipe, b)
+	_, err := pipe.Exec(ctx)
+	return wrapErr("StoreCommunityBench", err)
+}
+
+// addCommunityBench queues the commands storing b in the community layer.
+func addCommunityBench(ctx context.Context, pipe redis.Pipeliner, b bench.Bench) {
+	b.Community = true
+	pipe.GeoAdd(ctx, communityBenchesKey, &redis.GeoLocation{
+		Name:      b.GisID,
+		Longitude: b.Longitude,
+		Latitude:  b.Latitude,
+	})
+	pipe.HSet(ctx, benchHashKey(b.GisID), benchFields(b))
+	pipe.Incr(ctx, datasetVersionKey)
+}
+
 func (s *BenchStore) GetBenchByID(ctx context.Context, gisID string) (*bench.Bench, error) {
-	benchKey := fmt.Sprintf("bench:%s", gisID)
-	data, err := s.rdb.HGetAll(ctx, benchKey).Result()
+	data, err := s.rdb.HGetAll(ctx, benchHashKey(gisID)).Result()
 	if err != nil {
-		return nil, err
+		return nil, wrapErr("GetBenchByID", err)
 	}
 	if len(data) == 0 {
-		return nil, nil
+		return nil, fmt.Errorf("GetBenchByID %s: %w", gisID, storage.ErrNotFound)
+	}
+	return parseBench(gisID, data), nil
+}
+
+// GetBenchesByIDs loads the details of several benches in one round trip.
+// Benches without details are left out of the map.
+func (s *BenchStore) GetBenchesByIDs(ctx context.Context, gisIDs []string) (map[string]bench.Bench, error) {
+	benches := make(map[string]bench.Bench, len(gisIDs))
+	if len(gisIDs) == 0 {
+		return benches, nil
 	}
 
-	bench := &bench.Bench{
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(gisIDs))
+	for i, gisID := range gisIDs {
+		cmds[i] = pipe.HGetAll(ctx, benchHashKey(gisID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, wrapErr("GetBenchesByIDs", err)
+	}
+
+	for i, cmd := range cmds {
+		if data := cmd.Val(); len(data) > 0 {
+			benches[gisIDs[i]] = *parseBench(gisIDs[i], data)
+		}
+	}
+	return benches, nil
+}
+
+// FindNearbyWithDetails is FindNearby with every bench fully loaded, in two
+// round trips. Benches whose details are missing, which happens briefly
+// while the dataset is reloaded, are skipped.
+func (s *BenchStore) FindNearbyWithDetails(ctx context.Context, lat, lon float64, radiusMeters float64) ([]bench.Bench, error) {
+	nearby, err := s.FindNearby(ctx, lat, lon, radiusMeters)
+	if err != nil {
+		return nil, err
+	}
+
+	gisIDs := make([]string, len(nearby))
+	for i, b := range nearby {
+		gisIDs[i] = b.GisID
+	}
+	details, err := s.GetBenchesByIDs(ctx, gisIDs)
+	if err != nil {
+		return nil, wrapErr("FindNearbyWithDetails", err)
+	}
+
+	benches := make([]bench.Bench, 0, len(nearby))
+	for _, b := range nearby {
+		full, ok := details[b.GisID]
+		if !ok {
+			continue
+		}
+		full.Distance = b.Distance
+		full.Community = b.Community
+		benches = append(benches, full)
+	}
+	return benches, nil
+}
+
+// AllBenches returns every city and community bench with its details, loaded
+// in batches. Benches whose details are missing are skipped.
+func (s *BenchStore) AllBenches(ctx context.Context) ([]bench.Bench, error) {
+	const batch = 1000
+
+	var benches []bench.Bench
+	for _, key := range []string{benchesKey, communityBenchesKey} {
+		ids, err := s.rdb.ZRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return nil, wrapErr("AllBenches", err)
+		}
+		for i := 0; i < len(ids); i += batch {
+			chunk := ids[i:min(i+batch, len(ids))]
+			details, err := s.GetBenchesByIDs(ctx, chunk)
+			if err != nil {
+				return nil, wrapErr("AllBenches", err)
+			}
+			for _, id := range chunk {
+				if b, ok := details[id]; ok {
+					b.Community = key == communityBenchesKey
+					benches = append(benches, b)
+				}
+			}
+		}
+	}
+	return benches, nil
+}
+
+func parseBench(gisID string, data map[string]string) *bench.Bench {
+	b := &bench.Bench{
 		GisID:            gisID,
 		Type:             data["type"],
 		Code:             data["code"],
@@ -116,14 +310,58 @@ func (s *BenchStore) GetBenchByID(ctx context.Context, gisID string) (*bench.Ben
 		YETRS89:          data["y_etrs89"],
 		GeometryETRS89:   data["geometry_etrs89"],
 		GeometryWGS84:    data["geometry_wgs84"],
+		CreatedAt:        data["created_at"],
+		DeletedAt:        data["deleted_at"],
+		Community:        data["community"] == "1",
 	}
 
 	if lat, err := strconv.ParseFloat(data["latitude"], 64); err == nil {
-		bench.Latitude = lat
+		b.Latitude = lat
 	}
 	if lon, err := strconv.ParseFloat(data["longitude"], 64); err == nil {
-		bench.Longitude = lon
+		b.Longitude = lon
+	}
+
+	return b
+}
+
+// BenchExtent returns the bounding box of the city and community benches as
+// south, west, north, east. It fails with storage.ErrNotFound when there are
+// no benches.
+func (s *BenchStore) BenchExtent(ctx context.Context) (south, west, north, east float64, err error) {
+	const batch = 1000
+
+	found := false
+	for _, key := range []string{benchesKey, communityBenchesKey} {
+		ids, err := s.rdb.ZRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return 0, 0, 0, 0, wrapErr("BenchExtent", err)
+		}
+		for i := 0; i < len(ids); i += batch {
+			positions, err := s.rdb.GeoPos(ctx, key, ids[i:min(i+batch, len(ids))]...).Result()
+			if err != nil {
+				return 0, 0, 0, 0, wrapErr("BenchExtent", err)
+			}
+			for _, p := range positions {
+				if p == nil {
+					continue
+				}
+				if !found {
+					south, north, west, east = p.Latitude, p.Latitude, p.Longitude, p.Longitude
+					found = true
+				}
+				south, north = min(south, p.Latitude), max(north, p.Latitude)
+				west, east = min(west, p.Longitude), max(east, p.Longitude)
+			}
+		}
 	}
+	if !found {
+		return 0, 0, 0, 0, wrapErr("BenchExtent", storage.ErrNotFound)
+	}
+	return south, west, north, east, nil
+}
 
-	return bench, nil
+// Close closes the Redis connection pool.
+func (s *BenchStore) Close() error {
+	return s.rdb.Close()
 }
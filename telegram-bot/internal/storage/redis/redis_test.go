@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	fixtureLat     = 41.3874
+	fixtureLon     = 2.1686
+	fixtureBenches = 60
+	fixtureRadius  = 500
+)
+
+// newFixtureStore returns a store backed by an in-memory Redis holding
+// fixtureBenches benches within fixtureRadius of fixtureLat, fixtureLon.
+func newFixtureStore(tb testing.TB) *BenchStore {
+	tb.Helper()
+	m := miniredis.RunT(tb)
+	s := NewBenchStore(m.Addr(), "", 0)
+	tb.Cleanup(func() { s.Close() })
+
+	benches := make([]bench.Bench, fixtureBenches)
+	for i := range benches {
+		// A grid of roughly 25 m steps around the centre.
+		benches[i] = bench.Bench{
+			GisID:            fmt.Sprintf("bench-%d", i),
+			Latitude:         fixtureLat + float64(i%8-4)*0.000225,
+			Longitude:        fixtureLon + float64(i/8-4)*0.0003,
+			Type:             "Banc",
+			Code:             fmt.Sprintf("B%04d", i),
+			Description:      "Banc de fusta",
+			DistrictName:     "Eixample",
+			NeighborhoodName: "la Dreta de l'Eixample",
+			StreetName:       "Passeig de Gràcia",
+			StreetNumber:     fmt.Sprint(i + 1),
+		}
+	}
+	if err := s.StoreBenches(context.Background(), benches); err != nil {
+		tb.Fatalf("storing fixture benches: %v", err)
+	}
+	return s
+}
+
+// findNearbySequential is how benches were loaded before
+// FindNearbyWithDetails: one GetBenchByID per bench found.
+func findNearbySequential(ctx context.Context, s *BenchStore, lat, lon, radius float64) ([]bench.Bench, error) {
+	nearby, err := s.FindNearby(ctx, lat, lon, radius)
+	if err != nil {
+		return nil, err
+	}
+	benches := make([]bench.Bench, 0, len(nearby))
+	for _, nb := range nearby {
+		full, err := s.GetBenchByID(ctx, nb.GisID)
+		if err != nil {
+			return nil, err
+		}
+		full.Distance = nb.Distance
+		benches = append(benches, *full)
+	}
+	return benches, nil
+}
+
+func BenchmarkFindNearbyWithDetails(b *testing.B) {
+	ctx := context.Background()
+	s := newFixtureStore(b)
+
+	benchmarks := []struct {
+		name string
+		find func(ctx context.Context, lat, lon, radius float64) ([]bench.Bench, error)
+	}{
+		{"sequential", func(ctx context.Context, lat, lon, radius float64) ([]bench.Bench, error) {
+			return findNearbySequential(ctx, s, lat, lon, radius)
+		}},
+		{"pipelined", s.FindNearbyWithDetails},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				benches, err := bm.find(ctx, fixtureLat, fixtureLon, fixtureRadius)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(benches) != fixtureBenches {
+					b.Fatalf("found %d benches, want %d", len(benches), fixtureBenches)
+				}
+			}
+		})
+	}
+}
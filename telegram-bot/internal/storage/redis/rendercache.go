@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/rendercache"
+)
+
+const renderCachePrefix = "render:"
+
+// setFileIDScript records the file ID only if the entry is still cached, so
+// that a late upload does not leave a hash without TTL behind.
+//
+// KEYS: entry
+// ARGV: file ID
+var setFileIDScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'file_id', ARGV[1])
+end
+return 0
+`)
+
+// GetRender returns the file ID of the map cached under key, or
+// rendercache.ErrMiss. Images are not kept in Redis, so maps that were not
+// sent yet are missed.
+func (s *BenchStore) GetRender(ctx context.Context, key string) (rendercache.Entry, error) {
+	fileID, err := s.rdb.HGet(ctx, renderCachePrefix+key, "file_id").Result()
+	if err != nil && err != redis.Nil {
+		return rendercache.Entry{}, wrapErr("GetRender", err)
+	}
+	if fileID == "" {
+		return rendercache.Entry{}, rendercache.ErrMiss
+	}
+	return rendercache.Entry{FileID: fileID}, nil
+}
+
+// PutRender caches a map under key for ttl. Only its file ID is kept, so
+// that the cache takes little memory however many maps are drawn.
+func (s *BenchStore) PutRender(ctx context.Context, key string, e rendercache.Entry, ttl time.Duration) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, renderCachePrefix+key, "file_id", e.FileID)
+	pipe.PExpire(ctx, renderCachePrefix+key, ttl)
+	_, err := pipe.Exec(ctx)
+	return wrapErr("PutRender", err)
+}
+
+// SetRenderFileID records the file ID of the map cached under key.
+func (s *BenchStore) SetRenderFileID(ctx context.Context, key, fileID string) error {
+	err := setFileIDScript.Run(ctx, s.rdb, []string{renderCachePrefix + key}, fileID).Err()
+	return wrapErr("SetRenderFileID", err)
+}
@@ -0,0 +1,238 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	reportSeqKey   = "reports:seq"
+	openReportsKey = "reports:open"
+
+	// reportDedupWindow is how long a user cannot report the same bench
+	// again, so that one user cannot flood the moderation queue.
+	reportDedupWindow = 24 * time.Hour
+)
+
+func reportKey(id int64) string {
+	return fmt.Sprintf("report:%d", id)
+}
+
+func benchReportsKey(gisID string) string {
+	return fmt.Sprintf("reports:bench:%s", gisID)
+}
+
+func reportDedupKey(userID int64, gisID string) string {
+	return fmt.Sprintf("reports:dedup:%d:%s", userID, gisID)
+}
+
+func pendingReportPhotoKey(userID int64) string {
+	return fmt.Sprintf("reports:pending_photo:%d", userID)
+}
+
+// CreateReport stores a new open report. It returns storage.ErrNotFound if
+// the bench is gone, and storage.ErrConflict if the reporter already
+// reported the bench within reportDedupWindow.
+func (s *BenchStore) CreateReport(ctx context.Context, report *bench.Report) error {
+	exists, err := s.rdb.Exists(ctx, benchHashKey(report.GisID)).Result()
+	if err != nil {
+		return wrapErr("CreateReport", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("CreateReport %s: %w", report.GisID, storage.ErrNotFound)
+	}
+
+	dedupKey := reportDedupKey(report.ReporterID, report.GisID)
+	fresh, err := s.rdb.SetNX(ctx, dedupKey, 1, reportDedupWindow).Result()
+	if err != nil {
+		return wrapErr("CreateReport", err)
+	}
+	if !fresh {
+		return fmt.Errorf("CreateReport %s by %d: %w", report.GisID, report.ReporterID, storage.ErrConflict)
+	}
+
+	id, err := s.rdb.Incr(ctx, reportSeqKey).Result()
+	if err != nil {
+		s.rdb.Del(ctx, dedupKey)
+		return wrapErr("CreateReport", err)
+	}
+	report.ID = id
+	if report.Status == "" {
+		report.Status = bench.ReportOpen
+	}
+	if report.CreatedAt.IsZero() {
+		report.CreatedAt = time.Now()
+	}
+
+	score := float64(report.CreatedAt.Unix())
+	member := strconv.FormatInt(id, 10)
+
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, reportKey(id), map[string]interface{}{
+		"gis_id":        report.GisID,
+		"category":      string(report.Category),
+		"status":        string(report.Status),
+		"reporter_id":   report.ReporterID,
+		"reporter_name": report.ReporterName,
+		"photo_file_id": report.PhotoFileID,
+		"created_at":    report.CreatedAt.Unix(),
+	})
+	pipe.ZAdd(ctx, openReportsKey, &redis.Z{Score: score, Member: member})
+	pipe.ZAdd(ctx, benchReportsKey(report.GisID), &redis.Z{Score: score, Member: member})
+	if _, err = pipe.Exec(ctx); err != nil {
+		s.rdb.Del(ctx, dedupKey)
+	}
+	return wrapErr("CreateReport", err)
+}
+
+func (s *BenchStore) GetReport(ctx context.Context, id int64) (*bench.Report, error) {
+	data, err := s.rdb.HGetAll(ctx, reportKey(id)).Result()
+	if err != nil {
+		return nil, wrapErr("GetReport", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("GetReport %d: %w", id, storage.ErrNotFound)
+	}
+	return parseReport(id, data), nil
+}
+
+func (s *BenchStore) AttachReportPhoto(ctx context.Context, id int64, photoFileID string) error {
+	return wrapErr("AttachReportPhoto", s.rdb.HSet(ctx, reportKey(id), "photo_file_id", photoFileID).Err())
+}
+
+// SetReportStatus moves a report out of (or back into) the moderation queue.
+func (s *BenchStore) SetReportStatus(ctx context.Context, id int64, status bench.ReportStatus) error {
+	report, err := s.GetReport(ctx, id)
+	if err != nil {
+		return wrapErr("SetReportStatus", err)
+	}
+
+	member := strconv.FormatInt(id, 10)
+	pipe := s.rdb.TxPipeline()
+	if status == bench.ReportOpen {
+		score := float64(report.CreatedAt.Unix())
+		pipe.HSet(ctx, reportKey(id), "status", string(status), "resolved_at", 0)
+		pipe.ZAdd(ctx, openReportsKey, &redis.Z{Score: score, Member: member})
+		pipe.ZAdd(ctx, benchReportsKey(report.GisID), &redis.Z{Score: score, Member: member})
+	} else {
+		pipe.HSet(ctx, reportKey(id), "status", string(status), "resolved_at", time.Now().Unix())
+		pipe.ZRem(ctx, openReportsKey, member)
+		pipe.ZRem(ctx, benchReportsKey(report.GisID), member)
+	}
+	_, err = pipe.Exec(ctx)
+	return wrapErr("SetReportStatus", err)
+}
+
+// ListOpenReports returns the oldest unresolved reports first.
+func (s *BenchStore) ListOpenReports(ctx context.Context, limit int64) ([]bench.Report, error) {
+	ids, err := s.rdb.ZRange(ctx, openReportsKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, wrapErr("ListOpenReports", err)
+	}
+	return s.getReports(ctx, ids)
+}
+
+// OpenReportsByBench returns the unresolved reports created after since, grouped by bench.
+func (s *BenchStore) OpenReportsByBench(ctx context.Context, gisIDs []string, since time.Time) (map[string][]bench.Report, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(gisIDs))
+	for i, gisID := range gisIDs {
+		cmds[i] = pipe.ZRangeByScore(ctx, benchReportsKey(gisID), &redis.ZRangeBy{
+			Min: strconv.FormatInt(since.Unix(), 10),
+			Max: "+inf",
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, wrapErr("OpenReportsByBench", err)
+	}
+
+	var ids []string
+	for _, cmd := range cmds {
+		ids = append(ids, cmd.Val()...)
+	}
+	reports, err := s.getReports(ctx, ids)
+	if err != nil {
+		return nil, wrapErr("OpenReportsByBench", err)
+	}
+
+	byBench := make(map[string][]bench.Report)
+	for _, r := range reports {
+		byBench[r.GisID] = append(byBench[r.GisID], r)
+	}
+	return byBench, nil
+}
+
+// SetPendingReportPhoto remembers that the next photo sent by userID belongs to the given report.
+func (s *BenchStore) SetPendingReportPhoto(ctx context.Context, userID, reportID int64, ttl time.Duration) error {
+	return wrapErr("SetPendingReportPhoto", s.rdb.Set(ctx, pendingReportPhotoKey(userID), reportID, ttl).Err())
+}
+
+// TakePendingReportPhoto returns and clears the report awaiting a photo from userID, or 0 if there is none.
+func (s *BenchStore) TakePendingReportPhoto(ctx context.Context, userID int64) (int64, error) {
+	val, err := s.rdb.GetDel(ctx, pendingReportPhotoKey(userID)).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, wrapErr("TakePendingReportPhoto", err)
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+func (s *BenchStore) getReports(ctx context.Context, ids []string) ([]bench.Report, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, fmt.Sprintf("report:%s", id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, wrapErr("getReports", err)
+	}
+
+	reports := make([]bench.Report, 0, len(ids))
+	for i, cmd := range cmds {
+		data := cmd.Val()
+		if len(data) == 0 {
+			continue
+		}
+		id, err := strconv.ParseInt(ids[i], 10, 64)
+		if err != nil {
+			continue
+		}
+		reports = append(reports, *parseReport(id, data))
+	}
+	return reports, nil
+}
+
+func parseReport(id int64, data map[string]string) *bench.Report {
+	report := &bench.Report{
+		ID:           id,
+		GisID:        data["gis_id"],
+		Category:     bench.ReportCategory(data["category"]),
+		Status:       bench.ReportStatus(data["status"]),
+		ReporterName: data["reporter_name"],
+		PhotoFileID:  data["photo_file_id"],
+	}
+
+	if reporterID, err := strconv.ParseInt(data["reporter_id"], 10, 64); err == nil {
+		report.ReporterID = reporterID
+	}
+	if createdAt, err := strconv.ParseInt(data["created_at"], 10, 64); err == nil {
+		report.CreatedAt = time.Unix(createdAt, 0)
+	}
+	if resolvedAt, err := strconv.ParseInt(data["resolved_at"], 10, 64); err == nil && resolvedAt > 0 {
+		report.ResolvedAt = time.Unix(resolvedAt, 0)
+	}
+
+	return report
+}
@@ -0,0 +1,222 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/storage"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	submissionSeqKey      = "submissions:seq"
+	pendingSubmissionsKey = "submissions:pending"
+)
+
+func submissionKey(id int64) string {
+	return fmt.Sprintf("submission:%d", id)
+}
+
+func submissionDraftKey(userID int64) string {
+	return fmt.Sprintf("submissions:draft:%d", userID)
+}
+
+// SubmissionDraft is a submission the user is still filling in.
+type SubmissionDraft struct {
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+func (s *BenchStore) CreateSubmission(ctx context.Context, submission *bench.Submission) error {
+	id, err := s.rdb.Incr(ctx, submissionSeqKey).Result()
+	if err != nil {
+		return wrapErr("CreateSubmission", err)
+	}
+	submission.ID = id
+	submission.Status = bench.SubmissionPending
+	if submission.CreatedAt.IsZero() {
+		submission.CreatedAt = time.Now()
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, submissionKey(id), map[string]interface{}{
+		"latitude":       submission.Latitude,
+		"longitude":      submission.Longitude,
+		"photo_file_id":  submission.PhotoFileID,
+		"submitter_id":   submission.SubmitterID,
+		"submitter_name": submission.SubmitterName,
+		"language":       submission.Language,
+		"status":         string(submission.Status),
+		"created_at":     submission.CreatedAt.Unix(),
+	})
+	pipe.ZAdd(ctx, pendingSubmissionsKey, &redis.Z{
+		Score:  float64(submission.CreatedAt.Unix()),
+		Member: strconv.FormatInt(id, 10),
+	})
+	_, err = pipe.Exec(ctx)
+	return wrapErr("CreateSubmission", err)
+}
+
+func (s *BenchStore) GetSubmission(ctx context.Context, id int64) (*bench.Submission, error) {
+	data, err := s.rdb.HGetAll(ctx, submissionKey(id)).Result()
+	if err != nil {
+		return nil, wrapErr("GetSubmission", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("GetSubmission %d: %w", id, storage.ErrNotFound)
+	}
+	return parseSubmission(id, data), nil
+}
+
+// ListPendingSubmissions returns the oldest submissions awaiting review first.
+func (s *BenchStore) ListPendingSubmissions(ctx context.Context, limit int64) ([]bench.Submission, error) {
+	ids, err := s.rdb.ZRange(ctx, pendingSubmissionsKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, wrapErr("ListPendingSubmissions", err)
+	}
+
+	submissions := make([]bench.Submission, 0, len(ids))
+	for _, idStr := range ids {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		submission, err := s.GetSubmission(ctx, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, wrapErr("ListPendingSubmissions", err)
+		}
+		submissions = append(submissions, *submission)
+	}
+	return submissions, nil
+}
+
+// reviewRetries bounds how often ReviewSubmission retries when the
+// submission changes while it is being reviewed.
+const reviewRetries = 3
+
+// ReviewSubmission approves or rejects a pending submission. Approved
+// submissions are added to the community bench layer in the same
+// transaction, so a submission is never approved without its bench.
+func (s *BenchStore) ReviewSubmission(ctx context.Context, id int64, status bench.SubmissionStatus) (*bench.Submission, error) {
+	if status != bench.SubmissionApproved && status != bench.SubmissionRejected {
+		return nil, fmt.Errorf("ReviewSubmission %d: invalid status %q", id, status)
+	}
+
+	var submission *bench.Submission
+	review := func(tx *redis.Tx) error {
+		data, err := tx.HGetAll(ctx, submissionKey(id)).Result()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("ReviewSubmission %d: %w", id, storage.ErrNotFound)
+		}
+		submission = parseSubmission(id, data)
+		if submission.Status != bench.SubmissionPending {
+			return fmt.Errorf("ReviewSubmission %d: already %s: %w", id, submission.Status, storage.ErrConflict)
+		}
+
+		submission.Status = status
+		submission.ReviewedAt = time.Now()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, submissionKey(id), "status", string(status), "reviewed_at", submission.ReviewedAt.Unix())
+			pipe.ZRem(ctx, pendingSubmissionsKey, strconv.FormatInt(id, 10))
+			if status == bench.SubmissionApproved {
+				addCommunityBench(ctx, pipe, submission.Bench())
+			}
+			return nil
+		})
+		return err
+	}
+
+	var err error
+	for range reviewRetries {
+		err = s.rdb.Watch(ctx, review, submissionKey(id))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, wrapErr("ReviewSubmission", err)
+	}
+	return submission, nil
+}
+
+func (s *BenchStore) StartSubmissionDraft(ctx context.Context, userID int64, ttl time.Duration) error {
+	key := submissionDraftKey(userID)
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "has_location", false)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return wrapErr("StartSubmissionDraft", err)
+}
+
+func (s *BenchStore) SetSubmissionDraftLocation(ctx context.Context, userID int64, lat, lon float64, ttl time.Duration) error {
+	key := submissionDraftKey(userID)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "has_location", true, "latitude", lat, "longitude", lon)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return wrapErr("SetSubmissionDraftLocation", err)
+}
+
+// GetSubmissionDraft returns the user's draft, or nil when there is none.
+func (s *BenchStore) GetSubmissionDraft(ctx context.Context, userID int64) (*SubmissionDraft, error) {
+	data, err := s.rdb.HGetAll(ctx, submissionDraftKey(userID)).Result()
+	if err != nil {
+		return nil, wrapErr("GetSubmissionDraft", err)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	draft := &SubmissionDraft{HasLocation: data["has_location"] == "1"}
+	if lat, err := strconv.ParseFloat(data["latitude"], 64); err == nil {
+		draft.Latitude = lat
+	}
+	if lon, err := strconv.ParseFloat(data["longitude"], 64); err == nil {
+		draft.Longitude = lon
+	}
+	return draft, nil
+}
+
+func (s *BenchStore) DeleteSubmissionDraft(ctx context.Context, userID int64) error {
+	return wrapErr("DeleteSubmissionDraft", s.rdb.Del(ctx, submissionDraftKey(userID)).Err())
+}
+
+func parseSubmission(id int64, data map[string]string) *bench.Submission {
+	submission := &bench.Submission{
+		ID:            id,
+		PhotoFileID:   data["photo_file_id"],
+		SubmitterName: data["submitter_name"],
+		Language:      data["language"],
+		Status:        bench.SubmissionStatus(data["status"]),
+	}
+
+	if lat, err := strconv.ParseFloat(data["latitude"], 64); err == nil {
+		submission.Latitude = lat
+	}
+	if lon, err := strconv.ParseFloat(data["longitude"], 64); err == nil {
+		submission.Longitude = lon
+	}
+	if submitterID, err := strconv.ParseInt(data["submitter_id"], 10, 64); err == nil {
+		submission.SubmitterID = submitterID
+	}
+	if createdAt, err := strconv.ParseInt(data["created_at"], 10, 64); err == nil {
+		submission.CreatedAt = time.Unix(createdAt, 0)
+	}
+	if reviewedAt, err := strconv.ParseInt(data["reviewed_at"], 10, 64); err == nil && reviewedAt > 0 {
+		submission.ReviewedAt = time.Unix(reviewedAt, 0)
+	}
+
+	return submission
+}
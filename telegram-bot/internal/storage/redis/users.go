@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+)
+
+func userKey(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func (s *BenchStore) SetUserLanguage(ctx context.Context, userID int64, language string) error {
+	return wrapErr("SetUserLanguage", s.rdb.HSet(ctx, userKey(userID), "language", language).Err())
+}
+
+// GetUserLanguage returns the language the user picked with /language, or an
+// empty string if they never did.
+func (s *BenchStore) GetUserLanguage(ctx context.Context, userID int64) (string, error) {
+	language, err := s.rdb.HGet(ctx, userKey(userID), "language").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return language, wrapErr("GetUserLanguage", err)
+}
+
+// GetUserSettings returns the user's settings, using the defaults for
+// anything they never changed.
+func (s *BenchStore) GetUserSettings(ctx context.Context, userID int64) (settings.Settings, error) {
+	prefs := settings.Default()
+
+	data, err := s.rdb.HGetAll(ctx, userKey(userID)).Result()
+	if err != nil {
+		return prefs, wrapErr("GetUserSettings", err)
+	}
+
+	prefs.Language = data["language"]
+	if radius, err := strconv.ParseFloat(data["radius"], 64); err == nil && settings.ValidRadius(radius) {
+		prefs.Radius = radius
+	}
+	if layers, ok := data["layers"]; ok {
+		prefs.Layers = make(map[settings.Layer]bool)
+		for _, l := range strings.Split(layers, ",") {
+			if layer := settings.Layer(l); layer.Valid() {
+				prefs.Layers[layer] = true
+			}
+		}
+	}
+	if style := maps.Style(data["map_style"]); style.Valid() {
+		prefs.MapStyle = style
+	}
+	if theme := data["theme"]; theme != "" {
+		prefs.Theme = theme
+	}
+	if units := settings.Units(data["units"]); units.Valid() {
+		prefs.Units = units
+	}
+	if count, err := strconv.Atoi(data["result_count"]); err == nil && settings.ValidResultCount(count) {
+		prefs.ResultCount = count
+	}
+
+	return prefs, nil
+}
+
+func (s *BenchStore) SaveUserSettings(ctx context.Context, userID int64, prefs settings.Settings) error {
+	var layers []string
+	for _, l := range settings.Layers {
+		if prefs.HasLayer(l) {
+			layers = append(layers, string(l))
+		}
+	}
+
+	err := s.rdb.HSet(ctx, userKey(userID), map[string]interface{}{
+		"language":     prefs.Language,
+		"radius":       prefs.Radius,
+		"layers":       strings.Join(layers, ","),
+		"map_style":    string(prefs.MapStyle),
+		"theme":        prefs.Theme,
+		"units":        string(prefs.Units),
+		"result_count": prefs.ResultCount,
+	}).Err()
+	return wrapErr("SaveUserSettings", err)
+}
+
+// SetLastSearch remembers where the user last searched, for /export.
+func (s *BenchStore) SetLastSearch(ctx context.Context, userID int64, lat, lon float64) error {
+	location := fmt.Sprintf("%.6f,%.6f", lat, lon)
+	return wrapErr("SetLastSearch", s.rdb.HSet(ctx, userKey(userID), "last_search", location).Err())
+}
+
+// GetLastSearch returns where the user last searched. ok is false if they
+// never did.
+func (s *BenchStore) GetLastSearch(ctx context.Context, userID int64) (lat, lon float64, ok bool, err error) {
+	location, err := s.rdb.HGet(ctx, userKey(userID), "last_search").Result()
+	if err == redis.Nil {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, wrapErr("GetLastSearch", err)
+	}
+	latStr, lonStr, _ := strings.Cut(location, ",")
+	lat, latErr := strconv.ParseFloat(latStr, 64)
+	lon, lonErr := strconv.ParseFloat(lonStr, 64)
+	if latErr != nil || lonErr != nil {
+		return 0, 0, false, nil
+	}
+	return lat, lon, true, nil
+}
@@ -2,11 +2,43 @@ package storage
 
 import (
 	"context"
+	"time"
 
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/settings"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
 )
 
 type BenchStorage interface {
 	StoreBenches(ctx context.Context, benches []bench.Bench) error
 	FindNearby(ctx context.Context, lat, lon float64, radiusMeters float64) ([]bench.Bench, error)
+	GetBenchByID(ctx context.Context, gisID string) (*bench.Bench, error)
+	GetBenchesByIDs(ctx context.Context, gisIDs []string) (map[string]bench.Bench, error)
+	FindNearbyWithDetails(ctx context.Context, lat, lon float64, radiusMeters float64) ([]bench.Bench, error)
+}
+
+type ReportStorage interface {
+	CreateReport(ctx context.Context, report *bench.Report) error
+	GetReport(ctx context.Context, id int64) (*bench.Report, error)
+	AttachReportPhoto(ctx context.Context, id int64, photoFileID string) error
+	SetReportStatus(ctx context.Context, id int64, status bench.ReportStatus) error
+	ListOpenReports(ctx context.Context, limit int64) ([]bench.Report, error)
+	OpenReportsByBench(ctx context.Context, gisIDs []string, since time.Time) (map[string][]bench.Report, error)
+}
+
+type SubmissionStorage interface {
+	CreateSubmission(ctx context.Context, submission *bench.Submission) error
+	GetSubmission(ctx context.Context, id int64) (*bench.Submission, error)
+	ListPendingSubmissions(ctx context.Context, limit int64) ([]bench.Submission, error)
+	ReviewSubmission(ctx context.Context, id int64, status bench.SubmissionStatus) (*bench.Submission, error)
+}
+
+type RatingStorage interface {
+	RateBench(ctx context.Context, rating bench.Rating) error
+	GetRating(ctx context.Context, gisID string, userID int64) (*bench.Rating, error)
+	RatingSummaries(ctx context.Context, gisIDs []string) (map[string]bench.RatingSummary, error)
+}
+
+type UserStorage interface {
+	GetUserSettings(ctx context.Context, userID int64) (settings.Settings, error)
+	SaveUserSettings(ctx context.Context, userID int64, prefs settings.Settings) error
 }
@@ -0,0 +1,131 @@
+// Package tilesets builds the tile sources of each map style from the config.
+package tilesets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/internal/config"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/maps"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+// Sets holds the tile source of every map style.
+type Sets struct {
+	Sources map[maps.Style]tiles.Source
+	// Cache is nil when the disk cache is disabled.
+	Cache *tiles.DiskCache
+
+	offline map[maps.Style]bool
+	closers []io.Closer
+}
+
+// Open builds the sources listed in cfg.TileSources for each style, followed
+// by the style's public tile server. Remote sources are served through the
+// disk cache. With cfg.TileOffline set, the public server is replaced by the
+// tiles already cached from it.
+func Open(cfg *config.Config) (*Sets, error) {
+	specs, err := cfg.TileSourceSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sets{
+		Sources: make(map[maps.Style]tiles.Source, len(maps.Styles)),
+		offline: make(map[maps.Style]bool),
+	}
+	if cfg.TileCacheMaxMB > 0 {
+		s.Cache, err = tiles.NewDiskCache(cfg.TileCacheDir, int64(cfg.TileCacheMaxMB)<<20, time.Duration(cfg.TileCacheTTLHours)*time.Hour, cacheNames(specs))
+		if err != nil {
+			return nil, fmt.Errorf("opening tile cache: %w", err)
+		}
+	}
+
+	for _, style := range maps.Styles {
+		var chain []tiles.Source
+		for i, spec := range specs[style] {
+			src, err := tiles.Open(spec, cfg.TileUserAgent)
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("opening %s tiles: %w", style, err)
+			}
+			if c, ok := src.(io.Closer); ok {
+				s.closers = append(s.closers, c)
+			}
+			if _, remote := src.(*tiles.HTTP); remote {
+				src = s.cached(sourceCacheName(style, i), src)
+			}
+			chain = append(chain, src)
+		}
+		switch {
+		case !cfg.TileOffline:
+			chain = append(chain, s.cached(CacheName(style), style.DefaultSource(cfg.TileUserAgent)))
+		case s.Cache != nil:
+			// Tiles seeded from the public server are still used offline.
+			chain = append(chain, s.Cache.Cached(CacheName(style)))
+		}
+		if len(chain) == 0 {
+			s.offline[style] = true
+			chain = append(chain, tiles.Empty)
+		}
+		s.Sources[style] = tiles.Fallback(chain...)
+	}
+	return s, nil
+}
+
+// CacheName is the name the tiles of style's public tile server are cached
+// under. Seeding the cache under it makes the bot render from those tiles.
+func CacheName(style maps.Style) string {
+	return string(style)
+}
+
+// sourceCacheName is the name the tiles of the i-th source of style in
+// TILE_SOURCES are cached under.
+func sourceCacheName(style maps.Style, i int) string {
+	return fmt.Sprintf("%s-%d", style, i)
+}
+
+// cacheNames lists every name tiles may be cached under, so that the cache
+// leaves other files alone.
+func cacheNames(specs map[maps.Style][]string) []string {
+	var names []string
+	for _, style := range maps.Styles {
+		names = append(names, CacheName(style))
+		for i := range specs[style] {
+			names = append(names, sourceCacheName(style, i))
+		}
+	}
+	return names
+}
+
+func (s *Sets) cached(name string, src tiles.Source) tiles.Source {
+	if s.Cache == nil {
+		return src
+	}
+	return s.Cache.Wrap(name, src)
+}
+
+// RendererOptions draws every style from its source. Styles without any
+// source get a blank base map and no attribution.
+func (s *Sets) RendererOptions() []maps.Option {
+	var opts []maps.Option
+	for _, style := range maps.Styles {
+		attribution := style.Attribution()
+		if s.offline[style] {
+			attribution = ""
+		}
+		opts = append(opts, maps.WithTiles(style, s.Sources[style], attribution))
+	}
+	return opts
+}
+
+// Close closes the sources backed by files.
+func (s *Sets) Close() error {
+	var errs []error
+	for _, c := range s.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Districts returns the names of the districts of benches, sorted.
+func Districts(benches []Bench) []string {
+	var names []string
+	for _, b := range benches {
+		if b.DistrictName != "" {
+			names = append(names, b.DistrictName)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// FindDistrict returns the name of the district that query names, matching
+// district codes and names regardless of case and accents. A query matching
+// part of a single district name is accepted too.
+func FindDistrict(benches []Bench, query string) (string, bool) {
+	q := foldName(query)
+	if q == "" {
+		return "", false
+	}
+
+	var partial []string
+	for _, b := range benches {
+		if b.DistrictName == "" {
+			continue
+		}
+		name := foldName(b.DistrictName)
+		if name == q || (b.DistrictCode != "" && strings.TrimLeft(b.DistrictCode, "0") == strings.TrimLeft(q, "0")) {
+			return b.DistrictName, true
+		}
+		if strings.Contains(name, q) && !slices.Contains(partial, b.DistrictName) {
+			partial = append(partial, b.DistrictName)
+		}
+	}
+	if len(partial) == 1 {
+		return partial[0], true
+	}
+	return "", false
+}
+
+// InDistrict returns the benches of the named district.
+func InDistrict(benches []Bench, district string) []Bench {
+	var in []Bench
+	for _, b := range benches {
+		if b.DistrictName == district {
+			in = append(in, b)
+		}
+	}
+	return in
+}
+
+// foldName lowercases s and strips its accents and punctuation, so that
+// "Sarrià-Sant Gervasi" and "sarria sant gervasi" compare equal.
+func foldName(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			space = false
+			sb.WriteRune(r)
+		default:
+			space = true
+		}
+	}
+	return sb.String()
+}
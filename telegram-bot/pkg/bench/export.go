@@ -0,0 +1,255 @@
+package bench
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ExportFormat is a file format benches can be exported to.
+type ExportFormat string
+
+const (
+	ExportGeoJSON ExportFormat = "geojson"
+	ExportGPX     ExportFormat = "gpx"
+	ExportKML     ExportFormat = "kml"
+)
+
+// ExportFormats lists the export formats, in display order.
+var ExportFormats = []ExportFormat{ExportGeoJSON, ExportGPX, ExportKML}
+
+func (f ExportFormat) Valid() bool {
+	return f == ExportGeoJSON || f == ExportGPX || f == ExportKML
+}
+
+// Extension returns the file name extension of the format, with its dot.
+func (f ExportFormat) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type of the format.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportGPX:
+		return "application/gpx+xml"
+	case ExportKML:
+		return "application/vnd.google-earth.kml+xml"
+	default:
+		return "application/geo+json"
+	}
+}
+
+// Export writes benches to w in format f.
+func Export(w io.Writer, f ExportFormat, benches []Bench) error {
+	switch f {
+	case ExportGeoJSON:
+		return WriteGeoJSON(w, benches)
+	case ExportGPX:
+		return WriteGPX(w, benches)
+	case ExportKML:
+		return WriteKML(w, benches)
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// attribute is a field of a bench as exported. Coordinates are not
+// attributes, they are the geometry of the exported point.
+type attribute struct {
+	Name  string
+	Value any
+}
+
+// attributes returns the fields of b that are set, named as in storage.
+func attributes(b Bench) []attribute {
+	fields := []attribute{
+		{"gis_id", b.GisID},
+		{"type", b.Type},
+		{"code", b.Code},
+		{"description", b.Description},
+		{"manufacturer", b.Manufacturer},
+		{"district_code", b.DistrictCode},
+		{"district_name", b.DistrictName},
+		{"neighborhood_code", b.NeighborhoodCode},
+		{"neighborhood_name", b.NeighborhoodName},
+		{"zone", b.Zone},
+		{"street_name", b.StreetName},
+		{"street_number", b.StreetNumber},
+		{"x_etrs89", b.XETRS89},
+		{"y_etrs89", b.YETRS89},
+		{"geometry_etrs89", b.GeometryETRS89},
+		{"geometry_wgs84", b.GeometryWGS84},
+		{"created_at", b.CreatedAt},
+		{"deleted_at", b.DeletedAt},
+	}
+	attrs := fields[:0]
+	for _, a := range fields {
+		if a.Value != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	if b.Community {
+		attrs = append(attrs, attribute{"community", true})
+	}
+	if b.Distance > 0 {
+		attrs = append(attrs, attribute{"distance_m", math.Round(b.Distance*10) / 10})
+	}
+	if b.Shaded {
+		attrs = append(attrs, attribute{"shaded", true})
+	}
+	return attrs
+}
+
+// FeatureCollection is a GeoJSON feature collection of benches.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a bench as a GeoJSON feature.
+type Feature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Geometry   Point          `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// Point is a GeoJSON point.
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// NewFeatureCollection returns benches as GeoJSON features.
+func NewFeatureCollection(benches []Bench) FeatureCollection {
+	fc := FeatureCollection{Type: "FeatureCollection", Features: make([]Feature, len(benches))}
+	for i, b := range benches {
+		props := make(map[string]any)
+		for _, a := range attributes(b) {
+			props[a.Name] = a.Value
+		}
+		fc.Features[i] = Feature{
+			Type:       "Feature",
+			ID:         b.GisID,
+			Geometry:   Point{Type: "Point", Coordinates: [2]float64{b.Longitude, b.Latitude}},
+			Properties: props,
+		}
+	}
+	return fc
+}
+
+// WriteGeoJSON writes benches as a GeoJSON feature collection.
+func WriteGeoJSON(w io.Writer, benches []Bench) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(NewFeatureCollection(benches))
+}
+
+// exportNamespace qualifies the GPX extension elements holding attributes.
+const exportNamespace = "https://github.com/vcaldo/where-is-my-bench/export/1"
+
+type gpxFile struct {
+	XMLName  xml.Name      `xml:"gpx"`
+	Version  string        `xml:"version,attr"`
+	Creator  string        `xml:"creator,attr"`
+	NS       string        `xml:"xmlns,attr"`
+	BenchNS  string        `xml:"xmlns:bench,attr"`
+	Waypoint []gpxWaypoint `xml:"wpt"`
+}
+
+type gpxWaypoint struct {
+	Lat        float64       `xml:"lat,attr"`
+	Lon        float64       `xml:"lon,attr"`
+	Name       string        `xml:"name"`
+	Desc       string        `xml:"desc,omitempty"`
+	Type       string        `xml:"type,omitempty"`
+	Extensions gpxAttributes `xml:"extensions"`
+}
+
+type gpxAttributes struct {
+	Fields []gpxField
+}
+
+type gpxField struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// WriteGPX writes benches as GPX 1.1 waypoints, with their attributes as
+// extensions.
+func WriteGPX(w io.Writer, benches []Bench) error {
+	f := gpxFile{
+		Version: "1.1",
+		Creator: "where-is-my-bench",
+		NS:      "http://www.topografix.com/GPX/1/1",
+		BenchNS: exportNamespace,
+	}
+	for _, b := range benches {
+		var ext gpxAttributes
+		for _, a := range attributes(b) {
+			ext.Fields = append(ext.Fields, gpxField{XMLName: xml.Name{Local: "bench:" + a.Name}, Value: fmt.Sprint(a.Value)})
+		}
+		f.Waypoint = append(f.Waypoint, gpxWaypoint{
+			Lat:        b.Latitude,
+			Lon:        b.Longitude,
+			Name:       b.Address(),
+			Desc:       b.Description,
+			Type:       b.Type,
+			Extensions: ext,
+		})
+	}
+	return writeXML(w, f)
+}
+
+type kmlFile struct {
+	XMLName    xml.Name       `xml:"kml"`
+	NS         string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string    `xml:"name"`
+	Description string    `xml:"description,omitempty"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Coordinates string    `xml:"Point>coordinates"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+// WriteKML writes benches as KML 2.2 placemarks, with their attributes as
+// extended data.
+func WriteKML(w io.Writer, benches []Bench) error {
+	f := kmlFile{NS: "http://www.opengis.net/kml/2.2", Name: "Benches"}
+	for _, b := range benches {
+		p := kmlPlacemark{
+			Name:        b.Address(),
+			Description: b.Description,
+			Coordinates: strconv.FormatFloat(b.Longitude, 'f', -1, 64) + "," + strconv.FormatFloat(b.Latitude, 'f', -1, 64),
+		}
+		for _, a := range attributes(b) {
+			p.Data = append(p.Data, kmlData{Name: a.Name, Value: fmt.Sprint(a.Value)})
+		}
+		f.Placemarks = append(f.Placemarks, p)
+	}
+	return writeXML(w, f)
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -3,6 +3,7 @@ package bench
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/newrelic/go-agent/v3/newrelic"
 )
@@ -28,6 +29,12 @@ type Bench struct {
 	GeometryWGS84    string  `json:"geometria_wgs84"`
 	CreatedAt        string  `json:"data_alta"`
 	DeletedAt        string  `json:"data_baixa"`
+	// Community is set for benches submitted by users rather than the city dataset.
+	Community bool `json:"community,omitempty"`
+	// Distance from the searched location in meters, only set on search results.
+	Distance float64 `json:"distance_m,omitempty"`
+	// Shaded is the shade estimate at search time, only set when shade data is available.
+	Shaded bool `json:"shaded,omitempty"`
 }
 
 func LoadBenches(ctx context.Context, jsonData []byte) ([]Bench, error) {
@@ -47,3 +54,17 @@ func LoadBenches(ctx context.Context, jsonData []byte) ([]Bench, error) {
 
 	return benches, nil
 }
+
+// Address returns a short human readable location for the bench.
+func (b Bench) Address() string {
+	switch {
+	case b.StreetName != "" && b.StreetNumber != "":
+		return fmt.Sprintf("%s, %s", b.StreetName, b.StreetNumber)
+	case b.StreetName != "":
+		return b.StreetName
+	case b.NeighborhoodName != "":
+		return b.NeighborhoodName
+	default:
+		return b.GisID
+	}
+}
@@ -0,0 +1,103 @@
+package bench
+
+import "math"
+
+type RatingTag string
+
+const (
+	TagShade    RatingTag = "shade"
+	TagBackrest RatingTag = "backrest"
+	TagQuiet    RatingTag = "quiet"
+	TagView     RatingTag = "view"
+)
+
+// RatingTags lists the tags offered to users, in display order.
+var RatingTags = []RatingTag{
+	TagShade,
+	TagBackrest,
+	TagQuiet,
+	TagView,
+}
+
+// Emoji returns the symbol used for the tag in compact listings.
+func (t RatingTag) Emoji() string {
+	switch t {
+	case TagShade:
+		return "🌳"
+	case TagBackrest:
+		return "🪑"
+	case TagQuiet:
+		return "🤫"
+	case TagView:
+		return "🌅"
+	default:
+		return ""
+	}
+}
+
+func (t RatingTag) Valid() bool {
+	for _, tag := range RatingTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Rating is a single user's opinion of a bench. Each user has at most one
+// rating per bench; rating again replaces it.
+type Rating struct {
+	GisID  string      `json:"gis_id"`
+	UserID int64       `json:"user_id"`
+	Stars  int         `json:"stars"`
+	Tags   []RatingTag `json:"tags"`
+}
+
+// RatingSummary aggregates all ratings of a bench.
+type RatingSummary struct {
+	Count int               `json:"count"`
+	Sum   int               `json:"sum"`
+	Tags  map[RatingTag]int `json:"tags"`
+}
+
+func (s RatingSummary) Average() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// TopTags returns the tags chosen by at least half of the raters.
+func (s RatingSummary) TopTags() []RatingTag {
+	var tags []RatingTag
+	for _, tag := range RatingTags {
+		if s.Count > 0 && s.Tags[tag]*2 >= s.Count {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+const (
+	// ratingPriorStars and ratingPriorWeight pull benches with few ratings
+	// towards an average score so one 5 star vote does not beat everything.
+	ratingPriorStars  = 3.0
+	ratingPriorWeight = 3.0
+
+	// ratingScoreWeight is the share of the blended score given to the rating,
+	// the rest goes to proximity.
+	ratingScoreWeight = 0.5
+)
+
+// BlendedScore ranks a bench by both proximity and rating. Higher is better.
+func BlendedScore(distance, radius float64, s RatingSummary) float64 {
+	proximity := 1.0
+	if radius > 0 {
+		proximity = 1 - math.Min(distance/radius, 1)
+	}
+
+	stars := (float64(s.Sum) + ratingPriorStars*ratingPriorWeight) / (float64(s.Count) + ratingPriorWeight)
+	rating := (stars - 1) / 4
+
+	return ratingScoreWeight*rating + (1-ratingScoreWeight)*proximity
+}
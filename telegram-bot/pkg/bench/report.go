@@ -0,0 +1,53 @@
+package bench
+
+import "time"
+
+type ReportCategory string
+
+const (
+	ReportBroken   ReportCategory = "broken"
+	ReportDirty    ReportCategory = "dirty"
+	ReportOccupied ReportCategory = "occupied"
+	ReportRemoved  ReportCategory = "removed"
+)
+
+// ReportCategories lists the categories offered to users, in display order.
+var ReportCategories = []ReportCategory{
+	ReportBroken,
+	ReportDirty,
+	ReportOccupied,
+	ReportRemoved,
+}
+
+func (c ReportCategory) Valid() bool {
+	for _, category := range ReportCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportResolved  ReportStatus = "resolved"
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+func (s ReportStatus) Valid() bool {
+	return s == ReportOpen || s == ReportResolved || s == ReportDismissed
+}
+
+type Report struct {
+	ID           int64          `json:"id"`
+	GisID        string         `json:"gis_id"`
+	Category     ReportCategory `json:"category"`
+	Status       ReportStatus   `json:"status"`
+	ReporterID   int64          `json:"reporter_id"`
+	ReporterName string         `json:"reporter_name"`
+	PhotoFileID  string         `json:"photo_file_id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ResolvedAt   time.Time      `json:"resolved_at"`
+}
@@ -0,0 +1,47 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+)
+
+type SubmissionStatus string
+
+const (
+	SubmissionPending  SubmissionStatus = "pending"
+	SubmissionApproved SubmissionStatus = "approved"
+	SubmissionRejected SubmissionStatus = "rejected"
+)
+
+func (s SubmissionStatus) Valid() bool {
+	return s == SubmissionPending || s == SubmissionApproved || s == SubmissionRejected
+}
+
+// Submission is a bench proposed by a user that is missing from the city dataset.
+type Submission struct {
+	ID            int64   `json:"id"`
+	Latitude      float64 `json:"latitude"`
+	Longitude     float64 `json:"longitude"`
+	PhotoFileID   string  `json:"photo_file_id"`
+	SubmitterID   int64   `json:"submitter_id"`
+	SubmitterName string  `json:"submitter_name"`
+	// Language is the submitter's locale, used to notify them of the review.
+	Language   string           `json:"language"`
+	Status     SubmissionStatus `json:"status"`
+	CreatedAt  time.Time        `json:"created_at"`
+	ReviewedAt time.Time        `json:"reviewed_at"`
+}
+
+// Bench builds the community bench for an approved submission.
+func (s Submission) Bench() Bench {
+	return Bench{
+		GisID:       fmt.Sprintf("community-%d", s.ID),
+		Type:        "Banc",
+		Code:        "community",
+		Description: "Banc afegit per la comunitat",
+		Latitude:    s.Latitude,
+		Longitude:   s.Longitude,
+		CreatedAt:   s.CreatedAt.Format(time.RFC3339),
+		Community:   true,
+	}
+}
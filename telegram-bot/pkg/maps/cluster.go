@@ -0,0 +1,85 @@
+package maps
+
+import (
+	"math"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+// clusterGap is how far apart, in pixels, the dots of two benches must be
+// not to be grouped.
+const clusterGap = 14.0
+
+// Cluster is a group of benches too close together at the rendered zoom to
+// be told apart, drawn as one bubble showing their count.
+type Cluster struct {
+	// Lat and Lon are the centre of the benches.
+	Lat, Lon float64
+	// Benches indexes the benches of the request, in increasing order.
+	Benches []int
+}
+
+// Clusters returns the clusters Render draws for req. Numbered benches and
+// the nearest bench are never clustered, so that they stay visible.
+//
+// The result only depends on req: benches are grouped greedily in request
+// order, each ungrouped bench collecting the ungrouped benches around it.
+func (r *Renderer) Clusters(req Request) []Cluster {
+	zoom, _, _ := r.frame(req)
+	return clusterBenches(req.Benches, req.Numbered, zoom, req.theme())
+}
+
+func clusterBenches(benches []bench.Bench, numbered, zoom int, theme *Theme) []Cluster {
+	distance := 2*theme.Sizes.Dot + clusterGap
+	nearest := nearestBench(benches)
+	type point struct{ x, y float64 }
+	points := make([]point, len(benches))
+	for i, b := range benches {
+		x, y := tiles.Position(b.Latitude, b.Longitude, zoom)
+		points[i] = point{x * tileSize, y * tileSize}
+	}
+
+	grouped := make([]bool, len(benches))
+	var clusters []Cluster
+	for i := numbered; i < len(benches); i++ {
+		if grouped[i] || i == nearest {
+			continue
+		}
+		members := []int{i}
+		for j := i + 1; j < len(benches); j++ {
+			if grouped[j] || j == nearest {
+				continue
+			}
+			if math.Hypot(points[j].x-points[i].x, points[j].y-points[i].y) <= distance {
+				members = append(members, j)
+			}
+		}
+		if len(members) < 2 {
+			continue
+		}
+
+		c := Cluster{Benches: members}
+		for _, m := range members {
+			grouped[m] = true
+			c.Lat += benches[m].Latitude
+			c.Lon += benches[m].Longitude
+		}
+		c.Lat /= float64(len(members))
+		c.Lon /= float64(len(members))
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// nearestBench returns the index of the closest bench, or -1 when there are
+// none. Ties go to the first one.
+func nearestBench(benches []bench.Bench) int {
+	nearest := -1
+	for i, b := range benches {
+		if nearest < 0 || b.Distance < benches[nearest].Distance {
+			nearest = i
+		}
+	}
+	return nearest
+}
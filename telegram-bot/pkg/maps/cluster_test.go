@@ -0,0 +1,72 @@
+package maps
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+const (
+	clusterLat = 41.3874
+	clusterLon = 2.1686
+)
+
+// clusterFixture has the first bench numbered and the second one nearest,
+// both next to a group of three benches. Another pair lies about 190 px east
+// at zoom 18, and a lone bench twice as far.
+var clusterFixture = []bench.Bench{
+	{GisID: "numbered", Latitude: clusterLat, Longitude: clusterLon, Distance: 5},
+	{GisID: "nearest", Latitude: clusterLat, Longitude: clusterLon + 0.00002, Distance: 1},
+	{GisID: "a1", Latitude: clusterLat, Longitude: clusterLon + 0.00004, Distance: 10},
+	{GisID: "a2", Latitude: clusterLat, Longitude: clusterLon + 0.00008, Distance: 12},
+	{GisID: "a3", Latitude: clusterLat, Longitude: clusterLon + 0.00012, Distance: 14},
+	{GisID: "b1", Latitude: clusterLat, Longitude: clusterLon + 0.001, Distance: 80},
+	{GisID: "b2", Latitude: clusterLat + 0.00005, Longitude: clusterLon + 0.001, Distance: 81},
+	{GisID: "lone", Latitude: clusterLat, Longitude: clusterLon + 0.002, Distance: 160},
+	// Close to a3 but not to a1, which starts the group.
+	{GisID: "chained", Latitude: clusterLat, Longitude: clusterLon + 0.00022, Distance: 20},
+}
+
+func TestClusterBenches(t *testing.T) {
+	tests := []struct {
+		name string
+		zoom int
+		want []Cluster
+	}{
+		{
+			name: "street zoom",
+			zoom: 18,
+			want: []Cluster{
+				{Lat: clusterLat, Lon: clusterLon + 0.00008, Benches: []int{2, 3, 4}},
+				{Lat: clusterLat + 0.000025, Lon: clusterLon + 0.001, Benches: []int{5, 6}},
+			},
+		},
+		{
+			name: "neighbourhood zoom",
+			zoom: 16,
+			want: []Cluster{
+				{Lat: clusterLat, Lon: clusterLon + 0.000115, Benches: []int{2, 3, 4, 8}},
+				{Lat: clusterLat + 0.000025, Lon: clusterLon + 0.001, Benches: []int{5, 6}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clusterBenches(clusterFixture, 1, tt.zoom, DefaultTheme)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d clusters %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, c := range got {
+				want := tt.want[i]
+				if !reflect.DeepEqual(c.Benches, want.Benches) {
+					t.Errorf("cluster %d has benches %v, want %v", i, c.Benches, want.Benches)
+				}
+				if math.Abs(c.Lat-want.Lat) > 1e-9 || math.Abs(c.Lon-want.Lon) > 1e-9 {
+					t.Errorf("cluster %d is centred on %.7f,%.7f, want %.7f,%.7f", i, c.Lat, c.Lon, want.Lat, want.Lon)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package maps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Digest returns a hash of everything that changes the image Render draws
+// for req, so that a map can be cached under it. Tiles are not part of it:
+// cached maps keep the tiles they were drawn with.
+func (r *Renderer) Digest(req Request) string {
+	h := sha256.New()
+	zoom, width, height := r.frame(req)
+	theme := req.theme()
+	fmt.Fprintf(h, "%d %d %d %s %d\n", width, height, zoom, r.encoding.Format, r.encoding.Quality)
+	fmt.Fprintf(h, "%.6f %.6f %g %s %d %q\n", req.Lat, req.Lon, req.Radius, theme.style(req.Style), req.Numbered, req.RadiusLabel)
+	fmt.Fprintf(h, "%q\n", []string{req.Legend.You, req.Legend.Nearest, req.Legend.Bench, req.Legend.Community, req.Legend.Shaded, req.Legend.Cluster})
+	// Distances are only drawn through which bench is nearest.
+	fmt.Fprintf(h, "%d\n", nearestBench(req.Benches))
+	for _, b := range req.Benches {
+		fmt.Fprintf(h, "%.6f %.6f %t %t\n", b.Latitude, b.Longitude, b.Community, b.Shaded)
+	}
+	// The theme is hashed by value, as a themes file may change it under the
+	// same name. A Theme only holds values that encode.
+	data, _ := json.Marshal(theme)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
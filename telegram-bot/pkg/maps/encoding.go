@@ -0,0 +1,49 @@
+package maps
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Format is the image format maps are encoded in.
+type Format string
+
+const (
+	FormatPNG  Format = "png"
+	FormatJPEG Format = "jpeg"
+)
+
+func (f Format) Valid() bool {
+	return f == FormatPNG || f == FormatJPEG
+}
+
+// Extension returns the file extension of f, including the dot.
+func (f Format) Extension() string {
+	if f == FormatJPEG {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// Encoding is how a rendered map is encoded. Quality, from 1 to 100, only
+// applies to JPEG; zero uses jpeg.DefaultQuality.
+type Encoding struct {
+	Format  Format
+	Quality int
+}
+
+// DefaultEncoding is lossless PNG.
+var DefaultEncoding = Encoding{Format: FormatPNG}
+
+func (e Encoding) encode(w io.Writer, img image.Image) error {
+	if e.Format != FormatJPEG {
+		return png.Encode(w, img)
+	}
+	quality := e.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
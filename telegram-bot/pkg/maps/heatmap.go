@@ -0,0 +1,378 @@
+package maps
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	sm "github.com/flopp/go-staticmaps"
+	"github.com/fogleman/gg"
+	"github.com/golang/geo/s2"
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+// HeatmapMode is how a heatmap groups benches.
+type HeatmapMode string
+
+const (
+	// HeatmapGrid counts benches in square cells.
+	HeatmapGrid HeatmapMode = "grid"
+	// HeatmapNeighborhood counts benches per neighbourhood, drawn as the
+	// outline of its benches.
+	HeatmapNeighborhood HeatmapMode = "neighborhood"
+)
+
+// Valid reports whether m is a known mode.
+func (m HeatmapMode) Valid() bool {
+	return m == HeatmapGrid || m == HeatmapNeighborhood
+}
+
+// DefaultCellSize is the side of heatmap grid cells in metres.
+const DefaultCellSize = 250.0
+
+// ErrNoBenches is returned when a heatmap has no benches to count.
+var ErrNoBenches = errors.New("no benches to draw")
+
+// HeatmapRequest describes a heatmap.
+type HeatmapRequest struct {
+	Benches []bench.Bench
+	Mode    HeatmapMode
+	// CellSize is the side of grid cells in metres, DefaultCellSize if zero.
+	CellSize float64
+	Style    Style
+	// Title heads the colour scale. The scale is left out when it is empty.
+	Title string
+	// Width and Height override the renderer's size when both are set.
+	Width, Height int
+	// Theme is DefaultTheme when nil.
+	Theme *Theme
+}
+
+// HeatCell is an area of a heatmap and the number of benches in it.
+type HeatCell struct {
+	// Key is the neighbourhood code, or the row and column of a grid cell.
+	Key string
+	// Name is the neighbourhood name, empty for grid cells.
+	Name  string
+	Count int
+	// Outline is the square of a grid cell, or the convex hull of the
+	// benches of a neighbourhood.
+	Outline []s2.LatLng
+}
+
+// Heatmap groups the benches of req into cells, sorted by key. Neighbourhood
+// mode leaves out benches without a NeighborhoodCode.
+func Heatmap(req HeatmapRequest) []HeatCell {
+	size := req.CellSize
+	if size <= 0 {
+		size = DefaultCellSize
+	}
+	box, ok := benchBounds(req.Benches)
+	if !ok {
+		return nil
+	}
+
+	// Degrees spanned by size metres, using the latitude of the centre.
+	const metresPerDegree = 6371008.8 * math.Pi / 180
+	dLat := size / metresPerDegree
+	dLon := size / (metresPerDegree * math.Cos((box.South+box.North)/2*math.Pi/180))
+
+	type group struct {
+		name     string
+		row, col int
+		points   []s2.LatLng
+	}
+	groups := make(map[string]*group)
+	for _, b := range req.Benches {
+		var key string
+		var g group
+		switch req.Mode {
+		case HeatmapNeighborhood:
+			if b.NeighborhoodCode == "" {
+				continue
+			}
+			key, g.name = b.NeighborhoodCode, b.NeighborhoodName
+		default:
+			g.row = int(math.Floor((b.Latitude - box.South) / dLat))
+			g.col = int(math.Floor((b.Longitude - box.West) / dLon))
+			key = fmt.Sprintf("%03d,%03d", g.row, g.col)
+		}
+		if _, ok := groups[key]; !ok {
+			groups[key] = &g
+		}
+		groups[key].points = append(groups[key].points, s2.LatLngFromDegrees(b.Latitude, b.Longitude))
+	}
+
+	cells := make([]HeatCell, 0, len(groups))
+	for key, g := range groups {
+		cell := HeatCell{Key: key, Name: g.name, Count: len(g.points)}
+		if req.Mode == HeatmapNeighborhood {
+			cell.Outline = hull(g.points, dLat/4, dLon/4)
+		} else {
+			south := box.South + float64(g.row)*dLat
+			west := box.West + float64(g.col)*dLon
+			cell.Outline = []s2.LatLng{
+				s2.LatLngFromDegrees(south, west),
+				s2.LatLngFromDegrees(south+dLat, west),
+				s2.LatLngFromDegrees(south+dLat, west+dLon),
+				s2.LatLngFromDegrees(south, west+dLon),
+			}
+		}
+		cells = append(cells, cell)
+	}
+	slices.SortFunc(cells, func(a, b HeatCell) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return cells
+}
+
+// benchBounds returns the bounding box of benches, if there are any.
+func benchBounds(benches []bench.Bench) (tiles.BBox, bool) {
+	if len(benches) == 0 {
+		return tiles.BBox{}, false
+	}
+	box := tiles.BBox{South: 90, West: 180, North: -90, East: -180}
+	for _, b := range benches {
+		box.South, box.North = min(box.South, b.Latitude), max(box.North, b.Latitude)
+		box.West, box.East = min(box.West, b.Longitude), max(box.East, b.Longitude)
+	}
+	return box, true
+}
+
+// hull returns the convex hull of points, counter-clockwise. When the
+// points lie on a line, a box around them widened by dLat and dLon is
+// returned instead, so that they still cover an area.
+func hull(points []s2.LatLng, dLat, dLon float64) []s2.LatLng {
+	type point struct{ x, y float64 }
+	ps := make([]point, len(points))
+	for i, p := range points {
+		ps[i] = point{p.Lng.Degrees(), p.Lat.Degrees()}
+	}
+	slices.SortFunc(ps, func(a, b point) int {
+		return cmp.Or(cmp.Compare(a.x, b.x), cmp.Compare(a.y, b.y))
+	})
+	ps = slices.Compact(ps)
+
+	// Andrew's monotone chain.
+	cross := func(o, a, b point) float64 {
+		return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+	}
+	var h []point
+	if len(ps) >= 3 {
+		for _, p := range ps {
+			for len(h) >= 2 && cross(h[len(h)-2], h[len(h)-1], p) <= 0 {
+				h = h[:len(h)-1]
+			}
+			h = append(h, p)
+		}
+		lower := len(h) + 1
+		for i := len(ps) - 2; i >= 0; i-- {
+			for len(h) >= lower && cross(h[len(h)-2], h[len(h)-1], ps[i]) <= 0 {
+				h = h[:len(h)-1]
+			}
+			h = append(h, ps[i])
+		}
+		h = h[:len(h)-1]
+	}
+
+	if len(h) < 3 {
+		south, north := ps[0].y, ps[0].y
+		for _, p := range ps {
+			south, north = min(south, p.y), max(north, p.y)
+		}
+		south, north = south-dLat, north+dLat
+		west, east := ps[0].x-dLon, ps[len(ps)-1].x+dLon
+		return []s2.LatLng{
+			s2.LatLngFromDegrees(south, west),
+			s2.LatLngFromDegrees(south, east),
+			s2.LatLngFromDegrees(north, east),
+			s2.LatLngFromDegrees(north, west),
+		}
+	}
+
+	outline := make([]s2.LatLng, len(h))
+	for i, p := range h {
+		outline[i] = s2.LatLngFromDegrees(p.y, p.x)
+	}
+	return outline
+}
+
+// heatColor returns the colour of count on scale, which runs up to most.
+func heatColor(scale []Color, count, most int) color.NRGBA {
+	if most <= 1 {
+		return color.NRGBA(scale[len(scale)-1])
+	}
+	t := float64(count-1) / float64(most-1) * float64(len(scale)-1)
+	i := min(int(t), len(scale)-2)
+	f := t - float64(i)
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*f))
+	}
+	a, b := scale[i], scale[i+1]
+	return color.NRGBA{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), A: lerp(a.A, b.A)}
+}
+
+// HeatmapBytes renders the heatmap and returns the encoded image.
+func (r *Renderer) HeatmapBytes(ctx context.Context, req HeatmapRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.RenderHeatmap(ctx, &buf, req); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderHeatmap renders the heatmap and writes the encoded image to w. It
+// fails with ErrNoBenches when no bench falls in a cell.
+func (r *Renderer) RenderHeatmap(ctx context.Context, w io.Writer, req HeatmapRequest) error {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("generate_heatmap")
+	defer segment.End()
+
+	txn.AddAttribute("heatmap_mode", string(req.Mode))
+	txn.AddAttribute("benches_count", len(req.Benches))
+
+	cells := Heatmap(req)
+	if len(cells) == 0 {
+		return ErrNoBenches
+	}
+	most := 0
+	box := tiles.BBox{South: 90, West: 180, North: -90, East: -180}
+	for _, c := range cells {
+		most = max(most, c.Count)
+		for _, p := range c.Outline {
+			box.South, box.North = min(box.South, p.Lat.Degrees()), max(box.North, p.Lat.Degrees())
+			box.West, box.East = min(box.West, p.Lng.Degrees()), max(box.East, p.Lng.Degrees())
+		}
+	}
+
+	width, height := r.width, r.height
+	if req.Width > 0 && req.Height > 0 {
+		width, height = req.Width, req.Height
+	}
+	lat, lon, zoom := fitBounds(box, width, height, r.padding)
+	theme := req.Theme
+	if theme == nil {
+		theme = DefaultTheme
+	}
+	set := r.tileSet(theme.style(req.Style))
+	center := s2.LatLngFromDegrees(lat, lon)
+
+	scene := sm.NewContext()
+	scene.SetSize(width, height)
+	scene.SetZoom(zoom)
+	scene.SetTileProvider(sm.NewTileProviderNone())
+	scene.SetCache(nil)
+	scene.OverrideAttribution(set.attribution)
+	scene.SetCenter(center)
+	scene.AddObject(&tileLayer{ctx: ctx, source: set.source, center: center})
+
+	f := newFonts()
+	for _, c := range cells {
+		scene.AddObject(sm.NewArea(c.Outline, theme.Colors.Outline, heatColor(theme.HeatScale, c.Count, most), 1))
+	}
+	if req.Mode == HeatmapNeighborhood {
+		for _, c := range cells {
+			scene.AddObject(&heatLabel{pos: centroid(c.Outline), count: c.Count, theme: theme, fonts: f})
+		}
+	}
+
+	segment = txn.StartSegment("render_heatmap")
+	defer segment.End()
+	img, err := scene.Render()
+	if err != nil {
+		return err
+	}
+	dc := gg.NewContextForRGBA(toRGBA(img))
+	drawHeatScale(dc, f, theme, req.Title, most)
+	segment.End()
+
+	segment = txn.StartSegment("encode_heatmap")
+	defer segment.End()
+	return r.encoding.encode(w, dc.Image())
+}
+
+// centroid returns the mean of the vertices of outline.
+func centroid(outline []s2.LatLng) s2.LatLng {
+	var lat, lon float64
+	for _, p := range outline {
+		lat += p.Lat.Degrees()
+		lon += p.Lng.Degrees()
+	}
+	n := float64(len(outline))
+	return s2.LatLngFromDegrees(lat/n, lon/n)
+}
+
+// heatLabel writes the bench count of a neighbourhood over it.
+type heatLabel struct {
+	pos   s2.LatLng
+	count int
+	theme *Theme
+	fonts *fonts
+}
+
+func (l *heatLabel) Bounds() s2.Rect {
+	return s2.RectFromLatLng(l.pos)
+}
+
+func (l *heatLabel) ExtraMarginPixels() (float64, float64, float64, float64) {
+	return 0, 0, 0, 0
+}
+
+func (l *heatLabel) Draw(dc *gg.Context, trans *sm.Transformer) {
+	x, y := trans.LatLngToXY(l.pos)
+	text := strconv.Itoa(l.count)
+	dc.SetFontFace(l.fonts.marker)
+	dc.SetColor(l.theme.Colors.Panel)
+	for _, d := range [][2]float64{{-1.5, 0}, {1.5, 0}, {0, -1.5}, {0, 1.5}} {
+		dc.DrawStringAnchored(text, x+d[0], y+d[1], 0.5, 0.35)
+	}
+	dc.SetColor(l.theme.Colors.Text)
+	dc.DrawStringAnchored(text, x, y, 0.5, 0.35)
+}
+
+// drawHeatScale draws the colour scale from one bench to most benches in the
+// top left corner.
+func drawHeatScale(dc *gg.Context, f *fonts, theme *Theme, title string, most int) {
+	if title == "" {
+		return
+	}
+	const barWidth, barHeight = 240.0, 18.0
+
+	dc.SetFontFace(f.label)
+	titleWidth, textHeight := dc.MeasureString(title)
+	width := math.Max(titleWidth, barWidth) + 2*overlayPadding
+	height := 2*overlayPadding + 2*textHeight + barHeight + 20
+
+	dc.DrawRoundedRectangle(overlayMargin, overlayMargin, width, height, 10)
+	dc.SetColor(theme.Colors.Panel)
+	dc.Fill()
+
+	x := overlayMargin + overlayPadding
+	y := overlayMargin + overlayPadding
+	dc.SetColor(theme.Colors.Text)
+	dc.DrawStringAnchored(title, x, y+textHeight/2, 0, 0.35)
+
+	y += textHeight + 8
+	for i := 0.0; i < barWidth; i++ {
+		c := heatColor(theme.HeatScale, 1+int(math.Round(i/(barWidth-1)*float64(most-1))), most)
+		c.A = 255
+		dc.SetColor(c)
+		dc.DrawRectangle(x+i, y, 1, barHeight)
+		dc.Fill()
+	}
+
+	y += barHeight + 12
+	dc.SetColor(theme.Colors.Text)
+	dc.DrawStringAnchored("1", x, y+textHeight/2, 0, 0.35)
+	dc.DrawStringAnchored(strconv.Itoa(most), x+barWidth, y+textHeight/2, 1, 0.35)
+}
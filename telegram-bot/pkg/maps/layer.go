@@ -0,0 +1,117 @@
+package maps
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"sync"
+
+	sm "github.com/flopp/go-staticmaps"
+	"github.com/fogleman/gg"
+	"github.com/golang/geo/s2"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+const (
+	tileSize = 256
+	// tileFetchers bounds the tiles fetched at once for one map.
+	tileFetchers = 8
+)
+
+// tileLayer draws the base map. staticmaps can only download tiles itself,
+// so scenes use no tile provider and draw this layer first instead.
+type tileLayer struct {
+	ctx    context.Context
+	source tiles.Source
+	center s2.LatLng
+}
+
+func (l *tileLayer) Bounds() s2.Rect {
+	return s2.EmptyRect()
+}
+
+func (l *tileLayer) ExtraMarginPixels() (float64, float64, float64, float64) {
+	return 0, 0, 0, 0
+}
+
+func (l *tileLayer) Draw(dc *gg.Context, trans *sm.Transformer) {
+	zoom := transformerZoom(trans, l.center)
+	cx, cy := trans.LatLngToXY(l.center)
+	tx, ty := tiles.Position(l.center.Lat.Degrees(), l.center.Lng.Degrees(), zoom)
+
+	// Tile x, y has its top left corner at cx + (x-tx)*tileSize,
+	// cy + (y-ty)*tileSize.
+	minX := int(math.Floor(tx - cx/tileSize))
+	maxX := int(math.Floor(tx + (float64(dc.Width())-cx)/tileSize))
+	minY := int(math.Floor(ty - cy/tileSize))
+	maxY := int(math.Floor(ty + (float64(dc.Height())-cy)/tileSize))
+
+	type placed struct {
+		img  image.Image
+		x, y int
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		drawn  []placed
+		failed int
+		last   error
+	)
+	sem := make(chan struct{}, tileFetchers)
+	n := 1 << zoom
+	for x := minX; x <= maxX; x++ {
+		for y := max(minY, 0); y <= min(maxY, n-1); y++ {
+			// Wrap around the antimeridian.
+			t := tiles.Tile{Z: zoom, X: ((x % n) + n) % n, Y: y}
+			px := int(math.Round(cx + (float64(x)-tx)*tileSize))
+			py := int(math.Round(cy + (float64(y)-ty)*tileSize))
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				img, err := l.fetch(t)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failed++
+					last = err
+					return
+				}
+				drawn = append(drawn, placed{img, px, py})
+			}()
+		}
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		log.Printf("error fetching %d of %d map tiles: %v", failed, failed+len(drawn), last)
+	}
+	for _, p := range drawn {
+		dc.DrawImage(p.img, p.x, p.y)
+	}
+}
+
+func (l *tileLayer) fetch(t tiles.Tile) (image.Image, error) {
+	data, err := l.source.Tile(l.ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// transformerZoom recovers the zoom level staticmaps picked from the
+// longitude span of one tile around center.
+func transformerZoom(trans *sm.Transformer, center s2.LatLng) int {
+	x, y := trans.LatLngToXY(center)
+	span := trans.XYToLatLng(x+tileSize, y).Lng.Degrees() - trans.XYToLatLng(x, y).Lng.Degrees()
+	if span <= 0 {
+		span += 360
+	}
+	return int(math.Round(math.Log2(360 / span)))
+}
@@ -1,85 +1,223 @@
 package maps
 
 import (
+	"bytes"
 	"context"
-	"fmt"
-	"image/color"
-	"image/png"
-	"os"
-	"time"
+	"io"
 
 	sm "github.com/flopp/go-staticmaps"
+	"github.com/fogleman/gg"
 	"github.com/golang/geo/s2"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
 )
 
-type MapGenerator struct {
-	ctx *sm.Context
+// Renderer draws bench maps. Its configuration is fixed when it is built and
+// every call renders its own scene, so one Renderer can be shared by any
+// number of goroutines.
+type Renderer struct {
+	width, height int
+	padding       int
+	encoding      Encoding
+	userAgent     string
+	tileSets      map[Style]tileSet
 }
 
-func NewMapGenerator() *MapGenerator {
-	ctx := sm.NewContext()
-	ctx.SetSize(1600, 1200)
-	return &MapGenerator{ctx: ctx}
+type tileSet struct {
+	source      tiles.Source
+	attribution string
 }
 
-func (m *MapGenerator) GenerateMap(ctx context.Context, lat, lon, radius float64, benches []bench.Bench) (string, error) {
-	txn := newrelic.FromContext(ctx)
-	segment := txn.StartSegment("generate_map")
-	defer segment.End()
+// Option configures a Renderer.
+type Option func(*Renderer)
 
-	txn.AddAttribute("latitude", lat)
-	txn.AddAttribute("longitude", lon)
-	txn.AddAttribute("radius", radius)
-	txn.AddAttribute("benches_count", len(benches))
+// WithSize sets the size of the maps in pixels.
+func WithSize(width, height int) Option {
+	return func(r *Renderer) {
+		r.width, r.height = width, height
+	}
+}
 
-	m.ctx.SetCenter(s2.LatLngFromDegrees(lat, lon))
+// WithPadding sets how many pixels are kept free around the search circle
+// and the benches when fitting the zoom.
+func WithPadding(padding int) Option {
+	return func(r *Renderer) {
+		r.padding = padding
+	}
+}
 
-	circle := sm.NewCircle(s2.LatLngFromDegrees(lat, lon),
-		color.RGBA{R: 0, G: 0, B: 0, A: 128},
-		color.RGBA{R: 0, G: 0, B: 0, A: 64},
-		radius, 4.0)
-	m.ctx.AddObject(circle)
+// WithEncoding sets the image format maps are written in.
+func WithEncoding(e Encoding) Option {
+	return func(r *Renderer) {
+		r.encoding = e
+	}
+}
 
-	centerMarker := sm.NewMarker(
-		s2.LatLngFromDegrees(lat, lon),
-		color.RGBA{R: 255, G: 255, B: 0, A: 255},
-		24.0,
-	)
-	m.ctx.AddObject(centerMarker)
+// WithUserAgent sets the user agent the default tile sources send.
+func WithUserAgent(userAgent string) Option {
+	return func(r *Renderer) {
+		r.userAgent = userAgent
+	}
+}
 
-	segment = txn.StartSegment("add_benches")
-	defer segment.End()
-	for _, b := range benches {
-		marker := sm.NewMarker(
-			s2.LatLngFromDegrees(b.Latitude, b.Longitude),
-			color.RGBA{R: 255, G: 0, B: 0, A: 255},
-			16.0,
-		)
-		m.ctx.AddObject(marker)
+// WithTiles draws style from src instead of its public tile server.
+// attribution credits the tiles on the map.
+func WithTiles(style Style, src tiles.Source, attribution string) Option {
+	return func(r *Renderer) {
+		r.tileSets[style] = tileSet{source: src, attribution: attribution}
 	}
-	segment.End()
+}
 
-	segment = txn.StartSegment("render_map")
+func NewRenderer(opts ...Option) *Renderer {
+	r := &Renderer{
+		width:     1600,
+		height:    1200,
+		padding:   48,
+		encoding:  DefaultEncoding,
+		userAgent: DefaultUserAgent,
+		tileSets:  make(map[Style]tileSet, len(Styles)),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	for _, style := range Styles {
+		if _, ok := r.tileSets[style]; !ok {
+			r.tileSets[style] = tileSet{source: style.DefaultSource(r.userAgent), attribution: style.Attribution()}
+		}
+	}
+	return r
+}
+
+// Encoding returns the image format maps are written in.
+func (r *Renderer) Encoding() Encoding {
+	return r.encoding
+}
+
+// Request describes a single map: the benches within Radius metres of Lat,
+// Lon drawn over Style tiles.
+type Request struct {
+	Lat, Lon float64
+	Radius   float64
+	Benches  []bench.Bench
+	Style    Style
+	// Numbered is how many of the first Benches are numbered, to match the
+	// list sent along with the map.
+	Numbered int
+	Legend   Legend
+	// RadiusLabel is the radius in the user's units. The scale bar, as long
+	// as the radius, is only drawn when it is set.
+	RadiusLabel string
+	// Width and Height override the size of the renderer when set.
+	Width, Height int
+	// Zoom fixes the zoom level, from MinZoom to MaxZoom. By default the zoom
+	// is the largest that fits the search circle and the benches.
+	Zoom int
+	// Theme sets the colours and sizes, and may replace Style. It is
+	// DefaultTheme when nil.
+	Theme *Theme
+}
+
+func (req Request) theme() *Theme {
+	if req.Theme == nil {
+		return DefaultTheme
+	}
+	return req.Theme
+}
+
+// RenderBytes renders the map and returns the encoded image.
+func (r *Renderer) RenderBytes(ctx context.Context, req Request) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.Render(ctx, &buf, req); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Render renders the map and writes the encoded image to w.
+func (r *Renderer) Render(ctx context.Context, w io.Writer, req Request) error {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("generate_map")
 	defer segment.End()
 
-	img, err := m.ctx.Render()
+	txn.AddAttribute("latitude", req.Lat)
+	txn.AddAttribute("longitude", req.Lon)
+	txn.AddAttribute("radius", req.Radius)
+	txn.AddAttribute("benches_count", len(req.Benches))
+
+	f := newFonts()
+	scene, clusters := r.scene(ctx, req, f)
+
+	segment = txn.StartSegment("render_map")
+	defer segment.End()
+	img, err := scene.Render()
 	if err != nil {
-		return "", err
+		return err
 	}
-	segment.End()
-	filename := fmt.Sprintf("%d-map_%f_%f.png", time.Now().UnixMilli(), lat, lon)
-	f, err := os.Create(filename)
+	trans, err := scene.Transformer()
 	if err != nil {
+		return err
+	}
+	dc := gg.NewContextForRGBA(toRGBA(img))
+	theme := req.theme()
+	drawLegend(dc, f, theme, legendEntries(req.Legend, req, clusters, theme))
+	drawScaleBar(dc, f, theme, trans, s2.LatLngFromDegrees(req.Lat, req.Lon), req.Radius, req.RadiusLabel)
+	segment.End()
 
-		return "", err
+	segment = txn.StartSegment("encode_map")
+	defer segment.End()
+	return r.encoding.encode(w, dc.Image())
+}
+
+// tileSet returns the tiles of style, or of the standard style when there
+// are none.
+func (r *Renderer) tileSet(style Style) tileSet {
+	if set, ok := r.tileSets[style]; ok {
+		return set
 	}
-	defer f.Close()
+	return r.tileSets[StyleStandard]
+}
 
-	if err := png.Encode(f, img); err != nil {
-		return "", err
+// frame returns the zoom and size of the map for req.
+func (r *Renderer) frame(req Request) (zoom, width, height int) {
+	width, height = r.width, r.height
+	if req.Width > 0 && req.Height > 0 {
+		width, height = req.Width, req.Height
 	}
+	zoom = req.Zoom
+	if zoom <= 0 {
+		zoom = fitZoom(req, width, height, r.padding)
+	}
+	return min(zoom, MaxZoom), width, height
+}
 
-	return filename, nil
+// scene builds the staticmaps context for req, which is owned by the caller,
+// and returns the clusters drawn on it.
+func (r *Renderer) scene(ctx context.Context, req Request, f *fonts) (*sm.Context, []Cluster) {
+	segment := newrelic.FromContext(ctx).StartSegment("add_benches")
+	defer segment.End()
+
+	theme := req.theme()
+	set := r.tileSet(theme.style(req.Style))
+	center := s2.LatLngFromDegrees(req.Lat, req.Lon)
+
+	zoom, width, height := r.frame(req)
+
+	scene := sm.NewContext()
+	scene.SetSize(width, height)
+	scene.SetZoom(zoom)
+	scene.SetTileProvider(sm.NewTileProviderNone())
+	scene.SetCache(nil)
+	scene.OverrideAttribution(set.attribution)
+	scene.SetCenter(center)
+	scene.AddObject(&tileLayer{ctx: ctx, source: set.source, center: center})
+
+	scene.AddObject(sm.NewCircle(center, theme.Colors.Circle, theme.Colors.CircleFill, req.Radius, theme.Sizes.CircleWidth))
+
+	clusters := clusterBenches(req.Benches, req.Numbered, zoom, theme)
+	for _, m := range benchMarkers(req.Benches, req.Numbered, clusters, theme, f) {
+		scene.AddObject(m)
+	}
+	scene.AddObject(&youMarker{pos: center, theme: theme})
+	return scene, clusters
 }
@@ -0,0 +1,175 @@
+package maps
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+
+	sm "github.com/flopp/go-staticmaps"
+	"github.com/fogleman/gg"
+	"github.com/golang/geo/s2"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+// benchMarker is a bench drawn as a dot, or as a bubble holding its number
+// in the result list. The nearest bench gets a larger bubble with a dark ring.
+type benchMarker struct {
+	pos     s2.LatLng
+	color   color.Color
+	number  int
+	nearest bool
+	theme   *Theme
+	fonts   *fonts
+}
+
+func (m *benchMarker) radius() float64 {
+	switch {
+	case m.nearest:
+		return m.theme.Sizes.Nearest
+	case m.number > 0:
+		return m.theme.Sizes.Numbered
+	default:
+		return m.theme.Sizes.Dot
+	}
+}
+
+func (m *benchMarker) Bounds() s2.Rect {
+	return s2.RectFromLatLng(m.pos)
+}
+
+func (m *benchMarker) ExtraMarginPixels() (float64, float64, float64, float64) {
+	r := m.radius() + 3
+	return r, r, r, r
+}
+
+func (m *benchMarker) Draw(dc *gg.Context, trans *sm.Transformer) {
+	if !sm.CanDisplay(m.pos) {
+		return
+	}
+	x, y := trans.LatLngToXY(m.pos)
+	r := m.radius()
+
+	if m.nearest {
+		dc.DrawCircle(x, y, r+3)
+		dc.SetColor(m.theme.Colors.Ring)
+		dc.Fill()
+	}
+	dc.DrawCircle(x, y, r)
+	dc.SetColor(m.theme.Colors.Outline)
+	dc.Fill()
+	dc.DrawCircle(x, y, r-2.5)
+	dc.SetColor(m.color)
+	dc.Fill()
+
+	if m.number > 0 {
+		dc.SetFontFace(m.fonts.marker)
+		dc.SetColor(m.theme.Colors.Label)
+		dc.DrawStringAnchored(strconv.Itoa(m.number), x, y, 0.5, 0.35)
+	}
+}
+
+// youMarker is the searched location.
+type youMarker struct {
+	pos   s2.LatLng
+	theme *Theme
+}
+
+func (m *youMarker) Bounds() s2.Rect {
+	return s2.RectFromLatLng(m.pos)
+}
+
+func (m *youMarker) ExtraMarginPixels() (float64, float64, float64, float64) {
+	r := m.theme.Sizes.You
+	return r, r, r, r
+}
+
+func (m *youMarker) Draw(dc *gg.Context, trans *sm.Transformer) {
+	x, y := trans.LatLngToXY(m.pos)
+	r := m.theme.Sizes.You
+	dc.DrawCircle(x, y, r)
+	dc.SetColor(m.theme.Colors.Ring)
+	dc.Fill()
+	dc.DrawCircle(x, y, r-3)
+	dc.SetColor(m.theme.Colors.You)
+	dc.Fill()
+}
+
+// benchMarkers returns the markers of benches and clusters in drawing order,
+// so that numbered markers cover dots and clusters, lower numbers cover
+// higher ones and the nearest bench is on top.
+func benchMarkers(benches []bench.Bench, numbered int, clusters []Cluster, theme *Theme, f *fonts) []sm.MapObject {
+	nearest := nearestBench(benches)
+	clustered := make(map[int]bool)
+	var objects []sm.MapObject
+	for _, c := range clusters {
+		for _, i := range c.Benches {
+			clustered[i] = true
+		}
+		objects = append(objects, &clusterMarker{
+			pos:   s2.LatLngFromDegrees(c.Lat, c.Lon),
+			count: len(c.Benches),
+			theme: theme,
+			fonts: f,
+		})
+	}
+
+	for i := len(benches) - 1; i >= 0; i-- {
+		if i == nearest || clustered[i] {
+			continue
+		}
+		objects = append(objects, newBenchMarker(benches[i], i, numbered, false, theme, f))
+	}
+	if nearest >= 0 {
+		objects = append(objects, newBenchMarker(benches[nearest], nearest, numbered, true, theme, f))
+	}
+	return objects
+}
+
+func newBenchMarker(b bench.Bench, i, numbered int, nearest bool, theme *Theme, f *fonts) *benchMarker {
+	m := &benchMarker{
+		pos:     s2.LatLngFromDegrees(b.Latitude, b.Longitude),
+		color:   theme.benchColor(b),
+		nearest: nearest,
+		theme:   theme,
+		fonts:   f,
+	}
+	if i < numbered {
+		m.number = i + 1
+	}
+	return m
+}
+
+// clusterMarker is a bubble standing for count benches, growing with it.
+type clusterMarker struct {
+	pos   s2.LatLng
+	count int
+	theme *Theme
+	fonts *fonts
+}
+
+func (m *clusterMarker) radius() float64 {
+	return m.theme.Sizes.Numbered + 4*math.Log2(float64(m.count))
+}
+
+func (m *clusterMarker) Bounds() s2.Rect {
+	return s2.RectFromLatLng(m.pos)
+}
+
+func (m *clusterMarker) ExtraMarginPixels() (float64, float64, float64, float64) {
+	r := m.radius()
+	return r, r, r, r
+}
+
+func (m *clusterMarker) Draw(dc *gg.Context, trans *sm.Transformer) {
+	x, y := trans.LatLngToXY(m.pos)
+	r := m.radius()
+	dc.DrawCircle(x, y, r)
+	dc.SetColor(m.theme.Colors.Outline)
+	dc.Fill()
+	dc.DrawCircle(x, y, r-3)
+	dc.SetColor(m.theme.Colors.Cluster)
+	dc.Fill()
+	dc.SetFontFace(m.fonts.marker)
+	dc.SetColor(m.theme.Colors.Label)
+	dc.DrawStringAnchored(strconv.Itoa(m.count), x, y, 0.5, 0.35)
+}
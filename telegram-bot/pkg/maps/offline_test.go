@@ -0,0 +1,116 @@
+package maps
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/color"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+// fixtureTiles holds the zoom 16 tiles around fixtureLat, fixtureLon, all
+// filled with fixtureColor.
+const (
+	fixtureTiles = "testdata/tiles"
+	fixtureLat   = 41.3874
+	fixtureLon   = 2.1686
+)
+
+var fixtureColor = color.RGBA{200, 0, 200, 255}
+
+// newFixtureMBTiles copies the fixture tiles into an MBTiles file.
+func newFixtureMBTiles(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.mbtiles")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB)"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = filepath.WalkDir(fixtureTiles, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(fixtureTiles, strings.TrimSuffix(path, ".png"))
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		z, _ := strconv.Atoi(parts[0])
+		x, _ := strconv.Atoi(parts[1])
+		y, _ := strconv.Atoi(parts[2])
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// TMS counts rows from the bottom.
+		_, err = db.Exec("INSERT INTO tiles VALUES (?, ?, ?, ?)", z, x, (1<<z)-1-y, data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRenderOffline(t *testing.T) {
+	sources := []struct {
+		name string
+		spec func(t *testing.T) string
+	}{
+		{"dir", func(t *testing.T) string { return "dir:" + fixtureTiles }},
+		{"mbtiles", func(t *testing.T) string { return "mbtiles:" + newFixtureMBTiles(t) }},
+	}
+	for _, src := range sources {
+		t.Run(src.name, func(t *testing.T) {
+			source, err := tiles.Open(src.spec(t), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := source.(interface{ Close() error }); ok {
+				defer c.Close()
+			}
+
+			// A 250 m radius fits a 400 px map at zoom 16.
+			r := NewRenderer(WithSize(400, 400), WithTiles(StyleStandard, source, ""))
+			data, err := r.RenderBytes(context.Background(), Request{
+				Lat:     fixtureLat,
+				Lon:     fixtureLon,
+				Radius:  250,
+				Benches: []bench.Bench{{GisID: "a", Latitude: fixtureLat, Longitude: fixtureLon + 0.0005, Distance: 42}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Markers and the search circle cover part of the map, the
+			// fixture tiles should show everywhere else.
+			bounds := img.Bounds()
+			var tiled int
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if color.RGBAModel.Convert(img.At(x, y)) == fixtureColor {
+						tiled++
+					}
+				}
+			}
+			if share := float64(tiled) / float64(bounds.Dx()*bounds.Dy()); share < 0.5 {
+				t.Errorf("fixture tiles cover %.0f%% of the map, want at least half", share*100)
+			}
+		})
+	}
+}
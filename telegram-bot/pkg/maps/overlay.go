@@ -0,0 +1,200 @@
+package maps
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	sm "github.com/flopp/go-staticmaps"
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"github.com/golang/geo/s2"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// Legend holds the translated labels of the legend. Entries with an empty
+// label are left out, and so is the legend when every label is empty.
+type Legend struct {
+	You       string
+	Nearest   string
+	Bench     string
+	Community string
+	Shaded    string
+	Cluster   string
+}
+
+var parsedFonts = sync.OnceValues(func() (regular, bold *truetype.Font) {
+	// The embedded Go fonts are known to parse.
+	regular, _ = truetype.Parse(goregular.TTF)
+	bold, _ = truetype.Parse(gobold.TTF)
+	return regular, bold
+})
+
+// fonts are the faces of one render. Faces cache glyphs and are not safe for
+// concurrent use, so each render gets its own.
+type fonts struct {
+	marker font.Face
+	label  font.Face
+}
+
+func newFonts() *fonts {
+	regular, bold := parsedFonts()
+	return &fonts{
+		marker: truetype.NewFace(bold, &truetype.Options{Size: 20}),
+		label:  truetype.NewFace(regular, &truetype.Options{Size: 24}),
+	}
+}
+
+const (
+	overlayMargin  = 20.0
+	overlayPadding = 14.0
+)
+
+type legendEntry struct {
+	label   string
+	color   color.Color
+	nearest bool
+	you     bool
+}
+
+// legendEntries lists the legend entries that appear on the map.
+func legendEntries(legend Legend, req Request, clusters []Cluster, theme *Theme) []legendEntry {
+	var hasBench, hasCommunity, hasShaded bool
+	for _, b := range req.Benches {
+		switch {
+		case b.Community:
+			hasCommunity = true
+		case b.Shaded:
+			hasShaded = true
+		default:
+			hasBench = true
+		}
+	}
+
+	var entries []legendEntry
+	add := func(ok bool, e legendEntry) {
+		if ok && e.label != "" {
+			entries = append(entries, e)
+		}
+	}
+	c := theme.Colors
+	add(true, legendEntry{label: legend.You, color: c.You, you: true})
+	add(len(req.Benches) > 0, legendEntry{label: legend.Nearest, color: c.Bench, nearest: true})
+	add(hasBench, legendEntry{label: legend.Bench, color: c.Bench})
+	add(hasCommunity, legendEntry{label: legend.Community, color: c.Community})
+	add(hasShaded, legendEntry{label: legend.Shaded, color: c.Shaded})
+	add(len(clusters) > 0, legendEntry{label: legend.Cluster, color: c.Cluster})
+	return entries
+}
+
+// drawLegend draws the legend panel in the top left corner.
+func drawLegend(dc *gg.Context, f *fonts, theme *Theme, entries []legendEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	dc.SetFontFace(f.label)
+
+	c := theme.Colors
+	// The legend keeps the default marker sizes, so that large themes do
+	// not make it cover the map.
+	sizes := DefaultTheme.Sizes
+	const rowHeight = 40.0
+	width := 0.0
+	for _, e := range entries {
+		w, _ := dc.MeasureString(e.label)
+		width = math.Max(width, w)
+	}
+	width += 2*overlayPadding + 2*sizes.Nearest + 12
+	height := 2*overlayPadding + rowHeight*float64(len(entries))
+
+	dc.DrawRoundedRectangle(overlayMargin, overlayMargin, width, height, 10)
+	dc.SetColor(c.Panel)
+	dc.Fill()
+
+	x := overlayMargin + overlayPadding + sizes.Nearest
+	y := overlayMargin + overlayPadding + rowHeight/2
+	for _, e := range entries {
+		switch {
+		case e.you:
+			dc.DrawCircle(x, y, sizes.You)
+			dc.SetColor(c.Ring)
+			dc.Fill()
+			dc.DrawCircle(x, y, sizes.You-3)
+			dc.SetColor(e.color)
+			dc.Fill()
+		default:
+			r := sizes.Dot + 3
+			if e.nearest {
+				dc.DrawCircle(x, y, r+3)
+				dc.SetColor(c.Ring)
+				dc.Fill()
+			}
+			dc.DrawCircle(x, y, r)
+			dc.SetColor(c.Outline)
+			dc.Fill()
+			dc.DrawCircle(x, y, r-2.5)
+			dc.SetColor(e.color)
+			dc.Fill()
+		}
+		dc.SetColor(c.Text)
+		dc.DrawStringAnchored(e.label, x+sizes.Nearest+12, y, 0, 0.35)
+		y += rowHeight
+	}
+}
+
+// drawScaleBar draws a bar as long as the search radius in the bottom right
+// corner, above the attribution.
+func drawScaleBar(dc *gg.Context, f *fonts, theme *Theme, trans *sm.Transformer, center s2.LatLng, radius float64, label string) {
+	if radius <= 0 || label == "" {
+		return
+	}
+
+	// Metres per degree of longitude at the centre.
+	const earthRadius = 6371008.8
+	metresPerDegree := earthRadius * math.Pi / 180 * math.Cos(center.Lat.Radians())
+	east := s2.LatLngFromDegrees(center.Lat.Degrees(), center.Lng.Degrees()+radius/metresPerDegree)
+	x0, _ := trans.LatLngToXY(center)
+	x1, _ := trans.LatLngToXY(east)
+	length := x1 - x0
+	if length <= 0 || length > float64(dc.Width())/2 {
+		return
+	}
+
+	dc.SetFontFace(f.label)
+	textWidth, textHeight := dc.MeasureString(label)
+	width := math.Max(length, textWidth) + 2*overlayPadding
+	height := textHeight + 2*overlayPadding + 18
+	left := float64(dc.Width()) - overlayMargin - width
+	top := float64(dc.Height()) - 2*overlayMargin - height
+
+	dc.DrawRoundedRectangle(left, top, width, height, 10)
+	dc.SetColor(theme.Colors.Panel)
+	dc.Fill()
+
+	barLeft := left + (width-length)/2
+	barY := top + height - overlayPadding - 4
+	dc.SetColor(theme.Colors.Text)
+	dc.SetLineWidth(4)
+	dc.DrawLine(barLeft, barY, barLeft+length, barY)
+	dc.DrawLine(barLeft, barY-8, barLeft, barY+2)
+	dc.DrawLine(barLeft+length, barY-8, barLeft+length, barY+2)
+	dc.Stroke()
+	dc.DrawStringAnchored(label, left+width/2, top+overlayPadding+textHeight/2, 0.5, 0.35)
+}
+
+// toRGBA returns img as an *image.RGBA, which staticmaps already renders.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+	return rgba
+}
@@ -0,0 +1,60 @@
+package maps
+
+import "github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+
+// Style selects the base map tiles.
+type Style string
+
+const (
+	StyleStandard Style = "standard"
+	StyleLight    Style = "light"
+	StyleDark     Style = "dark"
+	StyleTopo     Style = "topo"
+)
+
+// Styles lists the available styles, in display order.
+var Styles = []Style{StyleStandard, StyleLight, StyleDark, StyleTopo}
+
+// DefaultUserAgent identifies the bot to tile servers, as their usage
+// policies require.
+const DefaultUserAgent = "where-is-my-bench (+https://github.com/vcaldo/where-is-my-bench)"
+
+func (s Style) Valid() bool {
+	for _, style := range Styles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSource returns the public tile server of s.
+func (s Style) DefaultSource(userAgent string) tiles.Source {
+	var template string
+	var shards []string
+	switch s {
+	case StyleLight, StyleDark:
+		template = "https://cartodb-basemaps-{s}.global.ssl.fastly.net/" + string(s) + "_all/{z}/{x}/{y}.png"
+		shards = []string{"a", "b", "c", "d"}
+	case StyleTopo:
+		template = "https://{s}.tile.opentopomap.org/{z}/{x}/{y}.png"
+		shards = []string{"a", "b", "c"}
+	default:
+		template = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+	}
+	// The templates are valid, so NewHTTP cannot fail.
+	src, _ := tiles.NewHTTP(template, userAgent)
+	return src.WithShards(shards...)
+}
+
+// Attribution is the credit the tiles of s must be shown with.
+func (s Style) Attribution() string {
+	switch s {
+	case StyleLight, StyleDark:
+		return "Map (c) Carto [CC BY 3.0] Data (c) OSM and contributors, ODbL."
+	case StyleTopo:
+		return "Maps (c) OpenTopoMap [CC-BY-SA]; Data (c) OSM and contributors [ODbL]; Data (c) SRTM"
+	default:
+		return "Maps and Data (c) openstreetmap.org and contributors, ODbL"
+	}
+}
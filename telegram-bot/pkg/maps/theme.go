@@ -0,0 +1,339 @@
+package maps
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/bench"
+)
+
+// ThemeDefault is the name of the theme used when none is chosen.
+const ThemeDefault = "default"
+
+const maxThemeName = 32
+
+// Theme is a named look for maps: the base map, the colours and the sizes of
+// what is drawn on it.
+type Theme struct {
+	Name string `json:"name"`
+	// Style is the base map. When empty, the style chosen by the user is used.
+	Style  Style       `json:"style,omitempty"`
+	Colors ThemeColors `json:"colors"`
+	Sizes  ThemeSizes  `json:"sizes"`
+	// HeatScale colours heatmaps from few benches to many.
+	HeatScale []Color `json:"heat_scale"`
+}
+
+// ThemeColors are the colours of a theme.
+type ThemeColors struct {
+	You       Color `json:"you"`
+	Bench     Color `json:"bench"`
+	Community Color `json:"community"`
+	Shaded    Color `json:"shaded"`
+	Cluster   Color `json:"cluster"`
+	// Outline surrounds markers, Ring the nearest bench and the searched
+	// location, and Label is the text on markers.
+	Outline Color `json:"outline"`
+	Ring    Color `json:"ring"`
+	Label   Color `json:"label"`
+	// Circle and CircleFill draw the search radius.
+	Circle     Color `json:"circle"`
+	CircleFill Color `json:"circle_fill"`
+	// Panel and Text draw the legend and scale bar.
+	Panel Color `json:"panel"`
+	Text  Color `json:"text"`
+}
+
+// ThemeSizes are the sizes of a theme in pixels: marker radii and the width
+// of the search circle.
+type ThemeSizes struct {
+	Dot         float64 `json:"dot"`
+	Numbered    float64 `json:"numbered"`
+	Nearest     float64 `json:"nearest"`
+	You         float64 `json:"you"`
+	CircleWidth float64 `json:"circle_width"`
+}
+
+// Color is a colour written as "#rrggbb" or "#rrggbbaa" in theme files.
+type Color color.NRGBA
+
+func (c Color) RGBA() (r, g, b, a uint32) {
+	return color.NRGBA(c).RGBA()
+}
+
+func (c Color) String() string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// ParseColor parses "#rrggbb" or "#rrggbbaa".
+func ParseColor(s string) (Color, error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || (len(hex) != 6 && len(hex) != 8) {
+		return Color{}, fmt.Errorf("colour %q must be #rrggbb or #rrggbbaa", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("colour %q must be #rrggbb or #rrggbbaa", s)
+	}
+	return Color{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseColor(s)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+func rgb(r, g, b uint8) Color {
+	return Color{R: r, G: g, B: b, A: 255}
+}
+
+// benchColor returns the fill of a bench marker.
+func (t *Theme) benchColor(b bench.Bench) Color {
+	switch {
+	case b.Community:
+		return t.Colors.Community
+	case b.Shaded:
+		return t.Colors.Shaded
+	default:
+		return t.Colors.Bench
+	}
+}
+
+// style returns the base map for a user who chose style.
+func (t *Theme) style(style Style) Style {
+	if t.Style != "" {
+		return t.Style
+	}
+	return style
+}
+
+func (t *Theme) validate() error {
+	// Names are sent in Telegram callback data, which is short.
+	if t.Name == "" || len(t.Name) > maxThemeName || strings.ContainsAny(t.Name, ": ") {
+		return fmt.Errorf("theme name %q must be 1 to %d characters without spaces or colons", t.Name, maxThemeName)
+	}
+	if t.Style != "" && !t.Style.Valid() {
+		return fmt.Errorf("theme %s: unknown map style %q", t.Name, t.Style)
+	}
+	s := t.Sizes
+	if s.Dot <= 0 || s.Numbered <= 0 || s.Nearest <= 0 || s.You <= 0 || s.CircleWidth < 0 {
+		return fmt.Errorf("theme %s: sizes must be positive", t.Name)
+	}
+	if len(t.HeatScale) < 2 {
+		return fmt.Errorf("theme %s: heat_scale needs at least two colours", t.Name)
+	}
+	return nil
+}
+
+// builtinThemes are always available. Themes files may change them.
+func builtinThemes() []*Theme {
+	sizes := ThemeSizes{Dot: 9, Numbered: 18, Nearest: 24, You: 14, CircleWidth: 4}
+	defaultTheme := &Theme{
+		Name: ThemeDefault,
+		Colors: ThemeColors{
+			You:        rgb(255, 200, 0),
+			Bench:      rgb(220, 30, 30),
+			Community:  rgb(0, 112, 255),
+			Shaded:     rgb(0, 140, 60),
+			Cluster:    rgb(120, 40, 40),
+			Outline:    rgb(255, 255, 255),
+			Ring:       rgb(20, 20, 20),
+			Label:      rgb(255, 255, 255),
+			Circle:     Color{A: 128},
+			CircleFill: Color{A: 64},
+			Panel:      Color{R: 255, G: 255, B: 255, A: 220},
+			Text:       rgb(20, 20, 20),
+		},
+		Sizes: sizes,
+		// Yellow to red.
+		HeatScale: []Color{
+			{R: 255, G: 255, B: 178, A: 180},
+			{R: 254, G: 204, B: 92, A: 180},
+			{R: 253, G: 141, B: 60, A: 180},
+			{R: 240, G: 59, B: 32, A: 180},
+			{R: 189, G: 0, B: 38, A: 180},
+		},
+	}
+
+	dark := *defaultTheme
+	dark.Name = "dark"
+	dark.Style = StyleDark
+	dark.Colors = ThemeColors{
+		You:        rgb(255, 214, 10),
+		Bench:      rgb(255, 85, 85),
+		Community:  rgb(80, 160, 255),
+		Shaded:     rgb(60, 200, 110),
+		Cluster:    rgb(190, 90, 90),
+		Outline:    rgb(25, 25, 25),
+		Ring:       rgb(240, 240, 240),
+		Label:      rgb(255, 255, 255),
+		Circle:     Color{R: 255, G: 255, B: 255, A: 150},
+		CircleFill: Color{R: 255, G: 255, B: 255, A: 36},
+		Panel:      Color{R: 30, G: 30, B: 30, A: 220},
+		Text:       rgb(235, 235, 235),
+	}
+
+	highContrast := *defaultTheme
+	highContrast.Name = "high_contrast"
+	highContrast.Style = StyleLight
+	highContrast.Colors = ThemeColors{
+		You:        rgb(255, 221, 0),
+		Bench:      rgb(200, 0, 0),
+		Community:  rgb(0, 60, 220),
+		Shaded:     rgb(0, 110, 40),
+		Cluster:    rgb(90, 0, 90),
+		Outline:    rgb(255, 255, 255),
+		Ring:       rgb(0, 0, 0),
+		Label:      rgb(255, 255, 255),
+		Circle:     rgb(0, 0, 0),
+		CircleFill: Color{A: 40},
+		Panel:      rgb(255, 255, 255),
+		Text:       rgb(0, 0, 0),
+	}
+	highContrast.Sizes = ThemeSizes{Dot: 12, Numbered: 22, Nearest: 28, You: 17, CircleWidth: 6}
+	highContrast.HeatScale = []Color{
+		{R: 255, G: 255, B: 204, A: 220},
+		{R: 253, G: 141, B: 60, A: 220},
+		{R: 128, G: 0, B: 38, A: 220},
+	}
+
+	// The Okabe-Ito palette and viridis, which stay apart for the common
+	// forms of colour blindness.
+	colorblind := *defaultTheme
+	colorblind.Name = "colorblind"
+	colorblind.Colors = ThemeColors{
+		You:        rgb(240, 228, 66),
+		Bench:      rgb(213, 94, 0),
+		Community:  rgb(0, 114, 178),
+		Shaded:     rgb(0, 158, 115),
+		Cluster:    rgb(204, 121, 167),
+		Outline:    rgb(255, 255, 255),
+		Ring:       rgb(0, 0, 0),
+		Label:      rgb(255, 255, 255),
+		Circle:     Color{A: 128},
+		CircleFill: Color{A: 64},
+		Panel:      Color{R: 255, G: 255, B: 255, A: 220},
+		Text:       rgb(0, 0, 0),
+	}
+	colorblind.HeatScale = []Color{
+		{R: 253, G: 231, B: 37, A: 190},
+		{R: 94, G: 201, B: 98, A: 190},
+		{R: 33, G: 145, B: 140, A: 190},
+		{R: 59, G: 82, B: 139, A: 190},
+		{R: 68, G: 1, B: 84, A: 190},
+	}
+
+	return []*Theme{defaultTheme, &dark, &highContrast, &colorblind}
+}
+
+// DefaultTheme is the built-in default theme.
+var DefaultTheme = builtinThemes()[0]
+
+// Themes are the themes available to users, by name.
+type Themes struct {
+	byName map[string]*Theme
+	names  []string
+}
+
+// NewThemes returns the built-in themes.
+func NewThemes() *Themes {
+	t := &Themes{byName: make(map[string]*Theme)}
+	for _, theme := range builtinThemes() {
+		t.add(theme)
+	}
+	return t
+}
+
+// LoadThemes returns the built-in themes together with the ones in the JSON
+// file at path, a list of themes. A theme named "base" starts from that
+// theme, the default one otherwise, and only overrides the fields it sets.
+// Themes with the name of an existing theme replace it.
+func LoadThemes(path string) (*Themes, error) {
+	t := NewThemes()
+	if path == "" {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading themes: %w", err)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing themes %s: %w", path, err)
+	}
+	for i, r := range raw {
+		var header struct {
+			Base string `json:"base"`
+		}
+		if err := json.Unmarshal(r, &header); err != nil {
+			return nil, fmt.Errorf("parsing theme %d of %s: %w", i+1, path, err)
+		}
+		base, ok := t.byName[cmp.Or(header.Base, ThemeDefault)]
+		if !ok {
+			return nil, fmt.Errorf("theme %d of %s: unknown base theme %q", i+1, path, header.Base)
+		}
+
+		theme := *base
+		theme.Name = ""
+		theme.HeatScale = slices.Clone(base.HeatScale)
+		if err := json.Unmarshal(r, &theme); err != nil {
+			return nil, fmt.Errorf("parsing theme %d of %s: %w", i+1, path, err)
+		}
+		if err := theme.validate(); err != nil {
+			return nil, fmt.Errorf("theme %d of %s: %w", i+1, path, err)
+		}
+		t.add(&theme)
+	}
+	return t, nil
+}
+
+func (t *Themes) add(theme *Theme) {
+	if _, ok := t.byName[theme.Name]; !ok {
+		t.names = append(t.names, theme.Name)
+	}
+	t.byName[theme.Name] = theme
+}
+
+// Get returns the named theme, or the default one when there is none.
+func (t *Themes) Get(name string) *Theme {
+	if theme, ok := t.byName[name]; ok {
+		return theme
+	}
+	return t.byName[ThemeDefault]
+}
+
+// Has reports whether there is a theme called name.
+func (t *Themes) Has(name string) bool {
+	_, ok := t.byName[name]
+	return ok
+}
+
+// Names lists the themes, built-in ones first, then in file order.
+func (t *Themes) Names() []string {
+	return t.names
+}
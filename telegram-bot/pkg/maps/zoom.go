@@ -0,0 +1,66 @@
+package maps
+
+import (
+	"math"
+
+	"github.com/vcaldo/where-is-my-bench/telegram-bot/pkg/tiles"
+)
+
+// Zoom levels a map can be drawn at.
+const (
+	MinZoom = 1
+	MaxZoom = 19
+)
+
+// fitZoom returns the largest zoom at which the search circle and every bench
+// fit in a width by height map centred on the searched location, leaving
+// padding pixels free at the edges.
+func fitZoom(req Request, width, height, padding int) int {
+	// Furthest extent from the centre in each direction, in tiles at zoom 0.
+	cx, cy := tiles.Position(req.Lat, req.Lon, 0)
+	var dx, dy float64
+	extend := func(lat, lon float64) {
+		x, y := tiles.Position(lat, lon, 0)
+		dx = math.Max(dx, math.Abs(x-cx))
+		dy = math.Max(dy, math.Abs(y-cy))
+	}
+
+	const metresPerDegree = 6371008.8 * math.Pi / 180
+	dLat := req.Radius / metresPerDegree
+	dLon := req.Radius / (metresPerDegree * math.Cos(req.Lat*math.Pi/180))
+	extend(req.Lat+dLat, req.Lon+dLon)
+	extend(req.Lat-dLat, req.Lon-dLon)
+	for _, b := range req.Benches {
+		extend(b.Latitude, b.Longitude)
+	}
+
+	return zoomFor(dx, dy, width, height, padding)
+}
+
+// fitBounds returns the centre of box and the largest zoom at which it fits
+// in a width by height map, leaving padding pixels free at the edges.
+func fitBounds(box tiles.BBox, width, height, padding int) (lat, lon float64, zoom int) {
+	west, north := tiles.Position(box.North, box.West, 0)
+	east, south := tiles.Position(box.South, box.East, 0)
+	lat, lon = (box.South+box.North)/2, (box.West+box.East)/2
+	return lat, lon, zoomFor((east-west)/2, (south-north)/2, width, height, padding)
+}
+
+// zoomFor returns the largest zoom at which dx and dy, distances from the
+// centre in tiles at zoom 0, fit in a width by height map.
+func zoomFor(dx, dy float64, width, height, padding int) int {
+	halfWidth := float64(width)/2 - float64(padding)
+	halfHeight := float64(height)/2 - float64(padding)
+	if halfWidth <= 0 || halfHeight <= 0 {
+		return MinZoom
+	}
+
+	zoom := MaxZoom
+	if dx > 0 {
+		zoom = min(zoom, int(math.Floor(math.Log2(halfWidth/(dx*tileSize)))))
+	}
+	if dy > 0 {
+		zoom = min(zoom, int(math.Floor(math.Log2(halfHeight/(dy*tileSize)))))
+	}
+	return max(MinZoom, zoom)
+}
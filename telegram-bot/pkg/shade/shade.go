@@ -0,0 +1,300 @@
+package shade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const (
+	earthRadius = 6371000.0
+
+	// cellSize is the side in meters of the grid used to index obstacles.
+	cellSize = 50.0
+	// maxShadowLength caps how far from a bench obstacles are considered, so
+	// a low sun does not scan half the city.
+	maxShadowLength = 150.0
+	// rayStep is the distance in meters between samples along the sun ray.
+	rayStep = 2.0
+
+	defaultBuildingHeight = 12.0
+	defaultTreeHeight     = 8.0
+	defaultTreeRadius     = 3.0
+)
+
+type obstacle struct {
+	height float64
+	// polygon ring in degrees (lon, lat), empty for trees
+	ring [][2]float64
+	// tree canopy centre (lon, lat) and radius in meters
+	center                         [2]float64
+	radius                         float64
+	minLon, minLat, maxLon, maxLat float64
+}
+
+type cell struct {
+	x, y int
+}
+
+// Index holds building footprints and tree canopies to estimate whether a
+// point is in the shade.
+type Index struct {
+	obstacles []obstacle
+	cells     map[cell][]int
+	refLat    float64
+	tallest   float64
+}
+
+// LoadIndex reads a GeoJSON FeatureCollection of obstacles. Polygons and
+// MultiPolygons are treated as buildings and Points as trees. The optional
+// "height" property (meters) overrides the default height, and trees accept a
+// "radius" property for the canopy size.
+func LoadIndex(ctx context.Context, path string) (*Index, error) {
+	txn := newrelic.FromContext(ctx)
+	segment := txn.StartSegment("load_shade_index")
+	defer segment.End()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fc featureCollection
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("error parsing shade dataset: %w", err)
+	}
+
+	idx := &Index{cells: make(map[cell][]int)}
+	for _, f := range fc.Features {
+		switch f.Geometry.Type {
+		case "Point":
+			var c [2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &c); err != nil {
+				return nil, err
+			}
+			idx.addTree(c, f.Properties.heightOr(defaultTreeHeight), f.Properties.radiusOr(defaultTreeRadius))
+		case "Polygon":
+			var rings [][][2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil {
+				return nil, err
+			}
+			if len(rings) > 0 {
+				idx.addBuilding(rings[0], f.Properties.heightOr(defaultBuildingHeight))
+			}
+		case "MultiPolygon":
+			var polygons [][][][2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polygons); err != nil {
+				return nil, err
+			}
+			for _, rings := range polygons {
+				if len(rings) > 0 {
+					idx.addBuilding(rings[0], f.Properties.heightOr(defaultBuildingHeight))
+				}
+			}
+		}
+	}
+
+	idx.buildGrid()
+	txn.AddAttribute("shade_obstacles_count", len(idx.obstacles))
+
+	return idx, nil
+}
+
+// Len returns the number of obstacles in the index.
+func (idx *Index) Len() int {
+	return len(idx.obstacles)
+}
+
+// IsShaded estimates whether the point is in the shade at time t. At night
+// every point is considered shaded.
+func (idx *Index) IsShaded(t time.Time, lat, lon float64) bool {
+	sun := SunAt(t, lat, lon)
+	if !sun.IsUp() {
+		return true
+	}
+
+	// Under a tree canopy the sun position does not matter much.
+	for _, i := range idx.near(lat, lon, 0) {
+		o := idx.obstacles[i]
+		if o.ring == nil && idx.distance(lat, lon, o.center[1], o.center[0]) <= o.radius {
+			return true
+		}
+	}
+
+	tanElevation := math.Tan(radians(sun.Elevation))
+	maxDistance := math.Min(maxShadowLength, idx.tallest/tanElevation)
+
+	azimuth := radians(sun.Azimuth)
+	dNorth, dEast := math.Cos(azimuth), math.Sin(azimuth)
+
+	candidates := idx.near(lat, lon, maxDistance)
+	if len(candidates) == 0 {
+		return false
+	}
+
+	for d := rayStep; d <= maxDistance; d += rayStep {
+		pLat := lat + degrees(d*dNorth/earthRadius)
+		pLon := lon + degrees(d*dEast/(earthRadius*math.Cos(radians(lat))))
+		needed := d * tanElevation
+
+		for _, i := range candidates {
+			o := idx.obstacles[i]
+			if o.height < needed {
+				continue
+			}
+			if o.ring == nil {
+				if idx.distance(pLat, pLon, o.center[1], o.center[0]) <= o.radius {
+					return true
+				}
+				continue
+			}
+			if pLon < o.minLon || pLon > o.maxLon || pLat < o.minLat || pLat > o.maxLat {
+				continue
+			}
+			if pointInRing(pLon, pLat, o.ring) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (idx *Index) addTree(center [2]float64, height, radius float64) {
+	dLat := degrees(radius / earthRadius)
+	dLon := degrees(radius / (earthRadius * math.Cos(radians(center[1]))))
+	idx.obstacles = append(idx.obstacles, obstacle{
+		height: height,
+		center: center,
+		radius: radius,
+		minLon: center[0] - dLon, maxLon: center[0] + dLon,
+		minLat: center[1] - dLat, maxLat: center[1] + dLat,
+	})
+}
+
+func (idx *Index) addBuilding(ring [][2]float64, height float64) {
+	if len(ring) < 3 {
+		return
+	}
+	o := obstacle{
+		height: height,
+		ring:   ring,
+		minLon: math.Inf(1), minLat: math.Inf(1),
+		maxLon: math.Inf(-1), maxLat: math.Inf(-1),
+	}
+	for _, p := range ring {
+		o.minLon = math.Min(o.minLon, p[0])
+		o.maxLon = math.Max(o.maxLon, p[0])
+		o.minLat = math.Min(o.minLat, p[1])
+		o.maxLat = math.Max(o.maxLat, p[1])
+	}
+	idx.obstacles = append(idx.obstacles, o)
+}
+
+func (idx *Index) buildGrid() {
+	if len(idx.obstacles) == 0 {
+		return
+	}
+
+	sum := 0.0
+	for _, o := range idx.obstacles {
+		sum += (o.minLat + o.maxLat) / 2
+	}
+	idx.refLat = sum / float64(len(idx.obstacles))
+
+	for _, o := range idx.obstacles {
+		idx.tallest = math.Max(idx.tallest, o.height)
+	}
+
+	for i, o := range idx.obstacles {
+		lo := idx.cellOf(o.minLat, o.minLon)
+		hi := idx.cellOf(o.maxLat, o.maxLon)
+		for x := lo.x; x <= hi.x; x++ {
+			for y := lo.y; y <= hi.y; y++ {
+				c := cell{x, y}
+				idx.cells[c] = append(idx.cells[c], i)
+			}
+		}
+	}
+}
+
+func (idx *Index) cellOf(lat, lon float64) cell {
+	x := radians(lon) * earthRadius * math.Cos(radians(idx.refLat))
+	y := radians(lat) * earthRadius
+	return cell{int(math.Floor(x / cellSize)), int(math.Floor(y / cellSize))}
+}
+
+// near returns the obstacles in the grid cells within radius meters of the point.
+func (idx *Index) near(lat, lon, radius float64) []int {
+	center := idx.cellOf(lat, lon)
+	span := int(math.Ceil(radius / cellSize))
+
+	seen := make(map[int]bool)
+	var found []int
+	for x := center.x - span; x <= center.x+span; x++ {
+		for y := center.y - span; y <= center.y+span; y++ {
+			for _, i := range idx.cells[cell{x, y}] {
+				if !seen[i] {
+					seen[i] = true
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	return found
+}
+
+// distance returns the approximate distance in meters between two nearby points.
+func (idx *Index) distance(lat1, lon1, lat2, lon2 float64) float64 {
+	x := radians(lon2-lon1) * math.Cos(radians((lat1+lat2)/2))
+	y := radians(lat2 - lat1)
+	return math.Sqrt(x*x+y*y) * earthRadius
+}
+
+func pointInRing(x, y float64, ring [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+type featureCollection struct {
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties properties `json:"properties"`
+}
+
+type properties struct {
+	Height *float64 `json:"height"`
+	Radius *float64 `json:"radius"`
+}
+
+func (p properties) heightOr(def float64) float64 {
+	if p.Height != nil && *p.Height > 0 {
+		return *p.Height
+	}
+	return def
+}
+
+func (p properties) radiusOr(def float64) float64 {
+	if p.Radius != nil && *p.Radius > 0 {
+		return *p.Radius
+	}
+	return def
+}
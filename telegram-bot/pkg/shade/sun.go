@@ -0,0 +1,63 @@
+package shade
+
+import (
+	"math"
+	"time"
+)
+
+// SunPosition is the apparent position of the sun seen from a point on earth.
+type SunPosition struct {
+	// Azimuth in degrees clockwise from north.
+	Azimuth float64
+	// Elevation in degrees above the horizon, negative at night.
+	Elevation float64
+}
+
+// SunAt computes the sun position at time t for the given coordinates using
+// the low precision formulas of the Astronomical Almanac, accurate to about a
+// hundredth of a degree, which is plenty for estimating shadows.
+func SunAt(t time.Time, lat, lon float64) SunPosition {
+	// Days since J2000.0
+	n := float64(t.UTC().UnixNano())/float64(24*time.Hour) - 10957.5
+
+	meanLongitude := normalizeDegrees(280.460 + 0.9856474*n)
+	meanAnomaly := radians(normalizeDegrees(357.528 + 0.9856003*n))
+	eclipticLongitude := radians(meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly))
+	obliquity := radians(23.439 - 0.0000004*n)
+
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+
+	siderealTime := normalizeDegrees(280.46061837 + 360.98564736629*n + lon)
+	hourAngle := radians(siderealTime) - rightAscension
+
+	phi := radians(lat)
+	elevation := math.Asin(math.Sin(phi)*math.Sin(declination) + math.Cos(phi)*math.Cos(declination)*math.Cos(hourAngle))
+	azimuth := math.Atan2(math.Sin(hourAngle), math.Cos(hourAngle)*math.Sin(phi)-math.Tan(declination)*math.Cos(phi))
+
+	return SunPosition{
+		Azimuth:   normalizeDegrees(degrees(azimuth) + 180),
+		Elevation: degrees(elevation),
+	}
+}
+
+// IsUp reports whether the sun is above the horizon.
+func (p SunPosition) IsUp() bool {
+	return p.Elevation > 0
+}
+
+func normalizeDegrees(d float64) float64 {
+	d = math.Mod(d, 360)
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
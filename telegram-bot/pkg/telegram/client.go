@@ -3,9 +3,11 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
 
 	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
 )
 
 type Client struct {
@@ -27,7 +29,17 @@ func NewClient(token string, options ...bot.Option) (*Client, error) {
 	}, nil
 }
 
+// GetMe returns the bot's own user, whose username commands may be addressed to.
+func (c *Client) GetMe(ctx context.Context) (*models.User, error) {
+	return c.bot.GetMe(ctx)
+}
+
+// Start receives updates by long polling until ctx is done.
 func (c *Client) Start(ctx context.Context) {
+	// A webhook left over from webhook mode would make polling fail.
+	if _, err := c.bot.DeleteWebhook(ctx, &bot.DeleteWebhookParams{}); err != nil {
+		log.Printf("error deleting webhook: %v", err)
+	}
 	c.bot.Start(ctx)
 }
 
@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+const (
+	secretTokenHeader      = "X-Telegram-Bot-Api-Secret-Token"
+	maxUpdateSize          = 1 << 20
+	webhookShutdownTimeout = 5 * time.Second
+)
+
+type WebhookConfig struct {
+	// URL is the public base URL Telegram sends updates to.
+	URL        string
+	ListenAddr string
+	// SecretToken is checked on every request and also derives the path
+	// updates are received on.
+	SecretToken string
+	// DeleteOnStop unregisters the webhook on shutdown. Disable it when
+	// several replicas share the webhook.
+	DeleteOnStop bool
+}
+
+// webhookPath derives the path from the secret, so that the path alone does
+// not reveal it in access logs.
+func webhookPath(secretToken string) string {
+	sum := sha256.Sum256([]byte(secretToken))
+	return "/telegram/" + hex.EncodeToString(sum[:16])
+}
+
+// StartWebhook serves Telegram updates over HTTP until ctx is done. It
+// registers the webhook once the listener is up.
+func (c *Client) StartWebhook(ctx context.Context, cfg WebhookConfig) error {
+	path := webhookPath(cfg.SecretToken)
+
+	mux := http.NewServeMux()
+	mux.Handle("POST "+path, verifySecretToken(cfg.SecretToken, c.bot.WebhookHandler()))
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", cfg.ListenAddr, err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	_, err = c.bot.SetWebhook(ctx, &bot.SetWebhookParams{
+		URL:         strings.TrimSuffix(cfg.URL, "/") + path,
+		SecretToken: cfg.SecretToken,
+	})
+	if err != nil {
+		srv.Close()
+		return fmt.Errorf("error setting webhook: %w", err)
+	}
+	log.Printf("webhook registered, listening on %s", cfg.ListenAddr)
+
+	workersDone := make(chan struct{})
+	go func() {
+		c.bot.StartWebhook(ctx)
+		close(workersDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		err = nil
+	case err = <-serveErr:
+		err = fmt.Errorf("webhook server stopped: %w", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+
+	if cfg.DeleteOnStop {
+		if _, delErr := c.bot.DeleteWebhook(shutdownCtx, &bot.DeleteWebhookParams{}); delErr != nil {
+			log.Printf("error deleting webhook: %v", delErr)
+		}
+	}
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil && !errors.Is(shutdownErr, http.ErrServerClosed) {
+		log.Printf("error shutting down webhook server: %v", shutdownErr)
+	}
+	if ctx.Err() != nil {
+		<-workersDone
+	}
+	return err
+}
+
+// verifySecretToken rejects requests that do not carry the secret Telegram
+// was given when the webhook was set.
+func verifySecretToken(secretToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(secretToken)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpdateSize)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package tiles
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxLatitude is the edge of the Web Mercator projection.
+const maxLatitude = 85.0511287798
+
+// BBox is a geographic bounding box in degrees.
+type BBox struct {
+	South, West, North, East float64
+}
+
+// ParseBBox parses "south,west,north,east".
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("bounding box %q is not south,west,north,east", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("bounding box %q: %w", s, err)
+		}
+		v[i] = f
+	}
+	b := BBox{South: v[0], West: v[1], North: v[2], East: v[3]}
+	if b.South > b.North || b.West > b.East || b.South < -90 || b.North > 90 || b.West < -180 || b.East > 180 {
+		return BBox{}, fmt.Errorf("bounding box %q is out of range", s)
+	}
+	return b, nil
+}
+
+// Position returns the fractional XYZ tile coordinates of lat, lon at zoom.
+func Position(lat, lon float64, zoom int) (x, y float64) {
+	n := math.Exp2(float64(zoom))
+	lat = max(-maxLatitude, min(maxLatitude, lat)) * math.Pi / 180
+	x = (lon + 180) / 360 * n
+	y = (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	return x, y
+}
+
+// tileRange returns the tiles covering b at zoom, inclusive.
+func (b BBox) tileRange(zoom int) (x0, y0, x1, y1 int) {
+	last := (1 << zoom) - 1
+	clamp := func(v float64) int { return max(0, min(last, int(math.Floor(v)))) }
+	fx0, fy0 := Position(b.North, b.West, zoom)
+	fx1, fy1 := Position(b.South, b.East, zoom)
+	return clamp(fx0), clamp(fy0), clamp(fx1), clamp(fy1)
+}
+
+// Count returns how many tiles cover b from minZoom to maxZoom.
+func (b BBox) Count(minZoom, maxZoom int) int {
+	n := 0
+	for z := minZoom; z <= maxZoom; z++ {
+		x0, y0, x1, y1 := b.tileRange(z)
+		n += (x1 - x0 + 1) * (y1 - y0 + 1)
+	}
+	return n
+}
+
+// Tiles yields the tiles covering b from minZoom to maxZoom, zoom level by
+// zoom level.
+func (b BBox) Tiles(minZoom, maxZoom int) iter.Seq[Tile] {
+	return func(yield func(Tile) bool) {
+		for z := minZoom; z <= maxZoom; z++ {
+			x0, y0, x1, y1 := b.tileRange(z)
+			for x := x0; x <= x1; x++ {
+				for y := y0; y <= y1; y++ {
+					if !yield(Tile{Z: z, X: x, Y: y}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package tiles
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskCache keeps tiles on disk in front of slower sources. Tiles older than
+// the TTL are fetched again, and the least recently used tiles are removed
+// once the cache grows past its size limit.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds *cacheEntry, most recently used first.
+	lru  *list.List
+	size int64
+}
+
+type cacheEntry struct {
+	key    string
+	size   int64
+	stored time.Time
+}
+
+// NewDiskCache opens the cache in dir, creating it if needed, and indexes
+// the tiles already there of the sources in names, the names given to Wrap.
+// Only <name>/<z>/<x>/<y> tile files are indexed and removed, so anything
+// else in dir is left alone.
+func NewDiskCache(dir string, maxBytes int64, ttl time.Duration, names []string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	var found []*cacheEntry
+	for _, name := range names {
+		err := filepath.WalkDir(filepath.Join(dir, name), func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && path == filepath.Join(dir, name) {
+				return fs.SkipDir
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			key, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			tile, partial := tileFile(key)
+			switch {
+			case partial:
+				// Left behind by a write that never finished.
+				return os.Remove(path)
+			case !tile:
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			found = append(found, &cacheEntry{key: key, size: info.Size(), stored: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Nothing records when tiles were last used, so the newest are assumed
+	// to be the most used.
+	sort.Slice(found, func(i, j int) bool { return found[i].stored.Before(found[j].stored) })
+	for _, e := range found {
+		c.entries[e.key] = c.lru.PushFront(e)
+		c.size += e.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Wrap returns a source serving the tiles of src through the cache. name
+// keeps the tiles of different sources apart and is used as a directory name.
+func (c *DiskCache) Wrap(name string, src Source) Source {
+	return &cachedSource{cache: c, name: name, src: src}
+}
+
+// Cached returns a source serving the tiles cached under name, however old,
+// without fetching any.
+func (c *DiskCache) Cached(name string) Source {
+	return &cacheOnlySource{cache: c, name: name}
+}
+
+// Stats returns the number of tiles in the cache and their total size.
+func (c *DiskCache) Stats() (tiles int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+// tileFile tells whether key, relative to the cache directory, is a tile
+// file <name>/<z>/<x>/<y> or one being written by put.
+func tileFile(key string) (tile, partial bool) {
+	parts := strings.Split(filepath.ToSlash(key), "/")
+	if len(parts) != 4 || !isNumber(parts[1]) || !isNumber(parts[2]) {
+		return false, false
+	}
+	if base, ok := strings.CutSuffix(parts[3], ".tmp"); ok {
+		return false, isNumber(base)
+	}
+	return isNumber(parts[3]), false
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+func (c *DiskCache) key(name string, t Tile) string {
+	return filepath.Join(name, strconv.Itoa(t.Z), strconv.Itoa(t.X), strconv.Itoa(t.Y))
+}
+
+// get returns a cached tile and whether it is still fresh.
+func (c *DiskCache) get(key string) (data []byte, fresh, ok bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false, false
+	}
+	c.lru.MoveToFront(el)
+	fresh = time.Since(el.Value.(*cacheEntry).stored) < c.ttl
+	c.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("error reading cached tile %s: %v", key, err)
+		}
+		c.mu.Lock()
+		c.remove(key)
+		c.mu.Unlock()
+		return nil, false, false
+	}
+	return data, fresh, true
+}
+
+func (c *DiskCache) put(key string, data []byte) error {
+	path := filepath.Join(c.dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Write then rename, so readers never see a partial tile.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		c.size += int64(len(data)) - e.size
+		e.size, e.stored = int64(len(data)), time.Now()
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: int64(len(data)), stored: time.Now()})
+		c.size += int64(len(data))
+	}
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used tiles until the cache fits. It keeps
+// at least the tile just stored. c.mu must be held.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 1 {
+		e := c.lru.Back().Value.(*cacheEntry)
+		if err := os.Remove(filepath.Join(c.dir, e.key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("error evicting cached tile %s: %v", e.key, err)
+		}
+		c.remove(e.key)
+	}
+}
+
+// remove forgets key. c.mu must be held.
+func (c *DiskCache) remove(key string) {
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+type cachedSource struct {
+	cache *DiskCache
+	name  string
+	src   Source
+}
+
+// Tile serves fresh tiles from the cache and fetches the others. A stale
+// tile is still served when the source fails, so a tile server outage does
+// not blank the maps.
+func (s *cachedSource) Tile(ctx context.Context, t Tile) ([]byte, error) {
+	key := s.cache.key(s.name, t)
+	cached, fresh, ok := s.cache.get(key)
+	if fresh {
+		return cached, nil
+	}
+
+	data, err := s.src.Tile(ctx, t)
+	if err != nil {
+		if ok && !errors.Is(err, ErrNotFound) && ctx.Err() == nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	if err := s.cache.put(key, data); err != nil {
+		log.Printf("error caching tile %s: %v", key, err)
+	}
+	return data, nil
+}
+
+type cacheOnlySource struct {
+	cache *DiskCache
+	name  string
+}
+
+func (s *cacheOnlySource) Tile(_ context.Context, t Tile) ([]byte, error) {
+	data, _, ok := s.cache.get(s.cache.key(s.name, t))
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
@@ -0,0 +1,48 @@
+package tiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewDiskCacheLeavesOtherFilesAlone(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]bool{
+		// Tiles of a known source, and a write that never finished.
+		"standard/16/33162/24477":    true,
+		"standard/16/33162/1234.tmp": false,
+		// Anything else stays.
+		"notes.txt":                  true,
+		"standard/readme.tmp":        true,
+		"standard/16/33162/tile.png": true,
+		"photos/16/1/2":              true,
+		"photos/16/1/5678.tmp":       true,
+	}
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Too small for even one tile, so eviction runs and would remove any
+	// other file it indexed.
+	c, err := NewDiskCache(dir, 1, time.Hour, []string{"standard", "dark"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Stats(); n != 1 {
+		t.Errorf("cache indexed %d tiles, want 1", n)
+	}
+	for name, kept := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("%s exists: %t, want %t", name, exists, kept)
+		}
+	}
+}
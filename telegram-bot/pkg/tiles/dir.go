@@ -0,0 +1,39 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Dir reads tiles from a directory laid out as <z>/<x>/<y>.png, or .jpg.
+type Dir struct {
+	root string
+}
+
+func NewDir(root string) (*Dir, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &Dir{root: root}, nil
+}
+
+func (d *Dir) Tile(_ context.Context, t Tile) ([]byte, error) {
+	base := filepath.Join(d.root, strconv.Itoa(t.Z), strconv.Itoa(t.X), strconv.Itoa(t.Y))
+	for _, ext := range []string{".png", ".jpg"} {
+		data, err := os.ReadFile(base + ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return data, err
+	}
+	return nil, ErrNotFound
+}
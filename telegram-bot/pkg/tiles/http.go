@@ -0,0 +1,83 @@
+package tiles
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP fetches tiles from a tile server.
+type HTTP struct {
+	template  string
+	shards    []string
+	userAgent string
+	client    *http.Client
+}
+
+// NewHTTP returns a source for the URL template, see Open.
+func NewHTTP(template, userAgent string) (*HTTP, error) {
+	for _, p := range []string{"{z}", "{x}", "{y}"} {
+		if !strings.Contains(template, p) {
+			return nil, fmt.Errorf("tile URL %q has no %s", template, p)
+		}
+	}
+	return &HTTP{
+		template:  template,
+		shards:    []string{"a", "b", "c"},
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// WithShards sets the subdomains {s} is replaced by.
+func (h *HTTP) WithShards(shards ...string) *HTTP {
+	h.shards = shards
+	return h
+}
+
+func (h *HTTP) url(t Tile) string {
+	shard := ""
+	if len(h.shards) > 0 {
+		shard = h.shards[(t.X+t.Y)%len(h.shards)]
+	}
+	return strings.NewReplacer(
+		"{s}", shard,
+		"{z}", strconv.Itoa(t.Z),
+		"{x}", strconv.Itoa(t.X),
+		"{y}", strconv.Itoa(t.Y),
+	).Replace(h.template)
+}
+
+func (h *HTTP) Tile(ctx context.Context, t Tile) ([]byte, error) {
+	if !t.Valid() {
+		return nil, ErrNotFound
+	}
+
+	url := h.url(t)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.userAgent != "" {
+		req.Header.Set("User-Agent", h.userAgent)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("GET %s: %w", url, ErrNotFound)
+	default:
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,49 @@
+package tiles
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// MBTiles reads tiles from an MBTiles file, a SQLite database of raster
+// tiles in the TMS scheme.
+type MBTiles struct {
+	db *sql.DB
+}
+
+func OpenMBTiles(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=query_only(1)")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return &MBTiles{db: db}, nil
+}
+
+func (m *MBTiles) Tile(ctx context.Context, t Tile) ([]byte, error) {
+	if !t.Valid() {
+		return nil, ErrNotFound
+	}
+
+	// TMS counts rows from the bottom.
+	row := (1 << t.Z) - 1 - t.Y
+	var data []byte
+	err := m.db.QueryRowContext(ctx,
+		"SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		t.Z, t.X, row).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
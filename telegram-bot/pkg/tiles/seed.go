@@ -0,0 +1,101 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log"
+	"sync"
+	"time"
+)
+
+// SeedStats counts the tiles handled by Seed.
+type SeedStats struct {
+	// Done is the number of tiles handled so far, whatever the outcome.
+	Done int
+	// Skipped tiles were already fresh in the cache.
+	Skipped int
+	Fetched int
+	// Missing tiles do not exist in the source.
+	Missing int
+	Failed  int
+	// Bytes is the size of the tiles fetched.
+	Bytes int64
+}
+
+// Seed stores the tiles of src in the cache under name, as Wrap(name, src)
+// would when rendering. Tiles that are already fresh are skipped, so an
+// interrupted seed resumes where it stopped. At most concurrency tiles are
+// fetched at once, and progress, if set, is called after every tile.
+//
+// Seed only fails when ctx is done; tiles that cannot be fetched are
+// counted in the stats.
+func (c *DiskCache) Seed(ctx context.Context, name string, src Source, tiles iter.Seq[Tile], concurrency int, progress func(SeedStats)) (SeedStats, error) {
+	var (
+		mu    sync.Mutex
+		stats SeedStats
+		wg    sync.WaitGroup
+	)
+	record := func(update func(*SeedStats)) {
+		mu.Lock()
+		defer mu.Unlock()
+		update(&stats)
+		stats.Done++
+		if progress != nil {
+			progress(stats)
+		}
+	}
+
+	sem := make(chan struct{}, max(1, concurrency))
+	for t := range tiles {
+		key := c.key(name, t)
+		if c.fresh(key) {
+			record(func(s *SeedStats) { s.Skipped++ })
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			data, err := src.Tile(ctx, t)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				record(func(s *SeedStats) { s.Missing++ })
+				return
+			case err != nil:
+				if ctx.Err() == nil {
+					log.Printf("error fetching tile %s: %v", t, err)
+				}
+				record(func(s *SeedStats) { s.Failed++ })
+				return
+			}
+			if err := c.put(key, data); err != nil {
+				log.Printf("error caching tile %s: %v", key, err)
+				record(func(s *SeedStats) { s.Failed++ })
+				return
+			}
+			record(func(s *SeedStats) {
+				s.Fetched++
+				s.Bytes += int64(len(data))
+			})
+		}()
+	}
+	wg.Wait()
+	return stats, ctx.Err()
+}
+
+// fresh reports whether key is cached and younger than the TTL.
+func (c *DiskCache) fresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	return ok && time.Since(el.Value.(*cacheEntry).stored) < c.ttl
+}
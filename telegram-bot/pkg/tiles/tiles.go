@@ -0,0 +1,91 @@
+// Package tiles fetches slippy map tiles from remote servers, local
+// directories and MBTiles files, with an optional disk cache in front.
+package tiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tile identifies a map tile in the XYZ scheme used by OpenStreetMap.
+type Tile struct {
+	Z, X, Y int
+}
+
+func (t Tile) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
+
+// Valid reports whether the tile exists at its zoom level.
+func (t Tile) Valid() bool {
+	n := 1 << t.Z
+	return t.Z >= 0 && t.Z <= 30 && t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// ErrNotFound is returned by sources that do not have a tile.
+var ErrNotFound = errors.New("tile not found")
+
+// Source returns encoded (PNG or JPEG) tile images.
+type Source interface {
+	Tile(ctx context.Context, t Tile) ([]byte, error)
+}
+
+// Empty is a source without any tiles, for rendering without a base map.
+var Empty Source = emptySource{}
+
+type emptySource struct{}
+
+func (emptySource) Tile(context.Context, Tile) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+// Fallback tries each source in order, returning the first tile found. It
+// only fails with ErrNotFound when every source lacks the tile; otherwise the
+// last other error is returned.
+func Fallback(sources ...Source) Source {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	return fallback(sources)
+}
+
+type fallback []Source
+
+func (f fallback) Tile(ctx context.Context, t Tile) ([]byte, error) {
+	err := ErrNotFound
+	for _, s := range f {
+		data, serr := s.Tile(ctx, t)
+		if serr == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(serr, ErrNotFound) {
+			err = serr
+		}
+	}
+	return nil, err
+}
+
+// Open returns the source described by spec:
+//
+//   - an http or https URL template with {z}, {x}, {y} and optionally {s},
+//     which is replaced by one of the a, b and c subdomains
+//   - "dir:<path>" for a directory laid out as <z>/<x>/<y>.png
+//   - "mbtiles:<path>" for an MBTiles file
+//
+// userAgent identifies the bot to remote tile servers.
+func Open(spec, userAgent string) (Source, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewHTTP(spec, userAgent)
+	case strings.HasPrefix(spec, "dir:"):
+		return NewDir(strings.TrimPrefix(spec, "dir:"))
+	case strings.HasPrefix(spec, "mbtiles:"):
+		return OpenMBTiles(strings.TrimPrefix(spec, "mbtiles:"))
+	}
+	return nil, fmt.Errorf("unknown tile source %q", spec)
+}